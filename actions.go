@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Editor actions, bound to keys through the keymap.
+
+func (e *Editor) Cancel() {
+	e.CurrentBuffer().Selection.Active = false
+	e.CurrentBuffer().Cursors = nil
+	e.CancelSearch()
+	e.SearchMatches = nil
+	e.SearchQuery = ""
+	e.StatusMsg = ""
+}
+
+func (e *Editor) NextPane() {
+	if len(e.Panes) > 1 {
+		e.autoSave(e.CurrentBuffer())
+		e.ActivePane = (e.ActivePane + 1) % len(e.Panes)
+	}
+}
+
+func (e *Editor) Copy() {
+	buf := e.CurrentBuffer()
+	if buf.Selection.Active && buf.Selection.Block {
+		e.clipboardBlock = e.blockText()
+		e.copyToClipboard(e.clipboardBlock, "Copied block to clipboard")
+		return
+	}
+	if len(buf.Cursors) > 0 {
+		if texts := e.selectedTexts(); len(texts) > 0 {
+			e.copyToClipboard(strings.Join(texts, "\n"), "Copied to clipboard")
+		}
+		return
+	}
+	if buf.Selection.Active {
+		e.copyToClipboard(buf.GetSelectedText(), "Copied to clipboard")
+	}
+}
+
+func (e *Editor) Paste() {
+	buf := e.CurrentBuffer()
+	if e.readOnly(buf) {
+		return
+	}
+	text, err := e.readClipboard()
+	defer func() {
+		if err != nil {
+			e.StatusMsg = fmt.Sprintf("Error: %v", err)
+		}
+	}()
+	if buf.Selection.Active && buf.Selection.Block {
+		e.blockToCursors()
+	}
+	if text != "" && text == e.clipboardBlock && len(buf.Cursors) == 0 && !buf.Selection.Active {
+		e.pasteBlock(text)
+		e.StatusMsg = e.clipboardMsg("Pasted block from clipboard")
+	} else if text != "" && len(buf.Cursors) > 0 {
+		e.pasteAtCursors(text)
+		e.StatusMsg = e.clipboardMsg("Pasted from clipboard")
+	} else if text != "" {
+		if buf.Selection.Active {
+			buf.DeleteSelection()
+		}
+		e.InsertText(text)
+		e.StatusMsg = e.clipboardMsg("Pasted from clipboard")
+	}
+}
+
+func (e *Editor) Cut() {
+	buf := e.CurrentBuffer()
+	if e.readOnly(buf) {
+		return
+	}
+	if buf.Selection.Active && buf.Selection.Block {
+		e.clipboardBlock = e.blockText()
+		e.blockToCursors()
+		e.cutAtCursors()
+		e.copyToClipboard(e.clipboardBlock, "Cut block to clipboard")
+		return
+	}
+	if len(buf.Cursors) > 0 {
+		if texts := e.selectedTexts(); len(texts) > 0 {
+			e.cutAtCursors()
+			e.copyToClipboard(strings.Join(texts, "\n"), "Cut to clipboard")
+		}
+		return
+	}
+	if buf.Selection.Active {
+		text := buf.GetSelectedText()
+		buf.DeleteSelection()
+		e.copyToClipboard(text, "Cut to clipboard")
+	}
+}
+
+func (e *Editor) Quit() {
+	if err := e.autoSaveAll(); err != nil {
+		e.StatusMsg = fmt.Sprintf("Error: %v", err)
+		return
+	}
+	e.exit()
+}
+
+func (e *Editor) Save() {
+	if err := e.CurrentBuffer().SaveFile(); err != nil {
+		e.StatusMsg = fmt.Sprintf("Error: %v", err)
+	} else {
+		e.StatusMsg = "Saved"
+	}
+}
+
+func (e *Editor) OpenCommandBar() {
+	e.CommandMode = true
+	e.Command = ""
+	e.historyIndex = len(e.commandHistory)
+}
+
+func (e *Editor) OpenSearch() {
+	e.SearchMode = true
+	e.SearchQuery = ""
+	e.SearchMatches = nil
+	e.SearchIndex = 0
+}
+
+func (e *Editor) SearchNext() {
+	if len(e.SearchMatches) > 0 {
+		e.SearchIndex = (e.SearchIndex + 1) % len(e.SearchMatches)
+		e.JumpToSearchMatch()
+	} else {
+		e.motionFailed = true
+	}
+}
+
+func (e *Editor) SearchPrev() {
+	if len(e.SearchMatches) > 0 {
+		e.SearchIndex = (e.SearchIndex - 1 + len(e.SearchMatches)) % len(e.SearchMatches)
+		e.JumpToSearchMatch()
+	} else {
+		e.motionFailed = true
+	}
+}
+
+// startMove opens a selection at the cursor when selecting and one is not
+// already open.
+func (b *Buffer) startMove(selecting bool) {
+	if selecting && !b.Selection.Active {
+		b.Selection.Active = true
+		b.Selection.Mark = false
+		b.Selection.StartLine = b.CursorY
+		b.Selection.StartCol = b.CursorX
+	}
+}
+
+// endMove extends the selection to the cursor, or drops it after a plain
+// move unless the mark is set.
+func (e *Editor) endMove(selecting bool) {
+	buf := e.CurrentBuffer()
+	if selecting || (buf.Selection.Active && buf.Selection.Mark) {
+		buf.Selection.EndLine = buf.CursorY
+		buf.Selection.EndCol = buf.CursorX
+	} else {
+		buf.Selection.Active = false
+	}
+	e.ScrollToCursor(e.CurrentPane())
+}
+
+func (e *Editor) MoveUp(selecting bool) {
+	buf := e.CurrentBuffer()
+	buf.startMove(selecting)
+	if buf.CursorY > 0 {
+		buf.CursorY--
+		lineLen := len([]rune(buf.Line(buf.CursorY)))
+		if buf.CursorX > lineLen {
+			buf.CursorX = lineLen
+		}
+	}
+	e.endMove(selecting)
+}
+
+func (e *Editor) MoveDown(selecting bool) {
+	buf := e.CurrentBuffer()
+	buf.startMove(selecting)
+	if buf.CursorY < buf.LineCount()-1 {
+		buf.CursorY++
+		lineLen := len([]rune(buf.Line(buf.CursorY)))
+		if buf.CursorX > lineLen {
+			buf.CursorX = lineLen
+		}
+	}
+	e.endMove(selecting)
+}
+
+func (e *Editor) MoveLeft(selecting, word bool) {
+	buf := e.CurrentBuffer()
+	buf.startMove(selecting)
+	if word {
+		buf.MoveWordLeft()
+	} else if buf.CursorX > 0 {
+		buf.CursorX--
+	} else if buf.CursorY > 0 {
+		buf.CursorY--
+		buf.CursorX = len([]rune(buf.Line(buf.CursorY)))
+	}
+	e.endMove(selecting)
+}
+
+func (e *Editor) MoveRight(selecting, word bool) {
+	buf := e.CurrentBuffer()
+	buf.startMove(selecting)
+	lineLen := len([]rune(buf.Line(buf.CursorY)))
+	if word {
+		buf.MoveWordRight()
+	} else if buf.CursorX < lineLen {
+		buf.CursorX++
+	} else if buf.CursorY < buf.LineCount()-1 {
+		buf.CursorY++
+		buf.CursorX = 0
+	}
+	e.endMove(selecting)
+}
+
+func (e *Editor) MoveLineStart(selecting bool) {
+	buf := e.CurrentBuffer()
+	buf.startMove(selecting)
+	buf.CursorX = 0
+	e.endMove(selecting)
+}
+
+func (e *Editor) MoveLineEnd(selecting bool) {
+	buf := e.CurrentBuffer()
+	buf.startMove(selecting)
+	buf.CursorX = buf.lineLen(buf.CursorY)
+	e.endMove(selecting)
+}
+
+// MoveHome goes to the first non-blank character of the line, or to column 0
+// if the cursor is already there.
+func (e *Editor) MoveHome(selecting bool) {
+	buf := e.CurrentBuffer()
+	buf.startMove(selecting)
+	if indent := buf.firstNonBlank(buf.CursorY); buf.CursorX != indent {
+		buf.CursorX = indent
+	} else {
+		buf.CursorX = 0
+	}
+	e.endMove(selecting)
+}
+
+// MovePage moves the cursor and the view a screen up (dir -1) or down.
+func (e *Editor) MovePage(selecting bool, dir int) {
+	buf := e.CurrentBuffer()
+	buf.startMove(selecting)
+	e.ScrollPage(e.CurrentPane(), dir)
+	e.endMove(selecting)
+}
+
+// MoveDocument goes to the start of the first line, or the end of the last
+// one when end is set.
+func (e *Editor) MoveDocument(selecting, end bool) {
+	buf := e.CurrentBuffer()
+	buf.startMove(selecting)
+	if end {
+		buf.CursorY = buf.LineCount() - 1
+		buf.CursorX = buf.lineLen(buf.CursorY)
+	} else {
+		buf.CursorY, buf.CursorX = 0, 0
+	}
+	e.endMove(selecting)
+}
+
+// ScrollView scrolls the view by lines without moving the cursor.
+func (e *Editor) ScrollView(lines int) {
+	buf := e.CurrentBuffer()
+	buf.OffsetY = min(max(buf.OffsetY+lines, 0), max(0, buf.LineCount()-1))
+}
+
+func (e *Editor) InsertNewline() {
+	buf := e.CurrentBuffer()
+	if e.readOnly(buf) {
+		return
+	}
+	if buf.Selection.Active {
+		buf.DeleteSelection()
+	}
+	runes := []rune(buf.Lines[buf.CursorY])
+	if buf.CursorX > len(runes) {
+		buf.CursorX = len(runes)
+	}
+	before, after := string(runes[:buf.CursorX]), string(runes[buf.CursorX:])
+	newLines := []string{after}
+	indent := ""
+	if e.Config.AutoIndent {
+		var between bool
+		indent, between = e.newlineIndent(buf, before, after)
+		after = strings.TrimLeft(after, " \t")
+		newLines = []string{indent + after}
+		if between {
+			// The closing bracket goes on the line after the cursor's
+			newLines = []string{indent, leadingSpace(before) + after}
+		}
+		if strings.TrimLeft(before, " \t") == "" {
+			// Indentation left on a blank line is dropped
+			before = ""
+		}
+	}
+	buf.Lines[buf.CursorY] = before
+	buf.Lines = append(buf.Lines[:buf.CursorY+1], append(newLines, buf.Lines[buf.CursorY+1:]...)...)
+	buf.MarkDirtyLines(buf.CursorY, buf.CursorY+len(newLines))
+	buf.CursorY++
+	buf.CursorX = len([]rune(indent))
+	e.ScrollToCursor(e.CurrentPane())
+}
+
+func (e *Editor) DeleteBack() {
+	buf := e.CurrentBuffer()
+	if e.readOnly(buf) {
+		return
+	}
+	if buf.Selection.Active {
+		buf.DeleteSelection()
+	} else if buf.CursorX > 0 {
+		runes := []rune(buf.Lines[buf.CursorY])
+		if buf.CursorX > len(runes) {
+			buf.CursorX = len(runes)
+		}
+		if buf.CursorX > 0 {
+			buf.Lines[buf.CursorY] = string(runes[:buf.CursorX-1]) + string(runes[buf.CursorX:])
+			buf.CursorX--
+			buf.MarkDirty()
+		}
+	} else if buf.CursorY > 0 {
+		prevRunes := []rune(buf.Lines[buf.CursorY-1])
+		buf.CursorX = len(prevRunes)
+		buf.Lines[buf.CursorY-1] = buf.Lines[buf.CursorY-1] + buf.Lines[buf.CursorY]
+		buf.Lines = append(buf.Lines[:buf.CursorY], buf.Lines[buf.CursorY+1:]...)
+		buf.CursorY--
+		buf.MarkDirtyLines(buf.CursorY, buf.CursorY)
+	}
+	e.ScrollToCursor(e.CurrentPane())
+}
+
+func (e *Editor) DeleteForward() {
+	buf := e.CurrentBuffer()
+	if e.readOnly(buf) {
+		return
+	}
+	if buf.Selection.Active {
+		buf.DeleteSelection()
+	} else {
+		runes := []rune(buf.Lines[buf.CursorY])
+		if buf.CursorX < len(runes) {
+			buf.Lines[buf.CursorY] = string(runes[:buf.CursorX]) + string(runes[buf.CursorX+1:])
+			buf.MarkDirty()
+		} else if buf.CursorY < len(buf.Lines)-1 {
+			buf.Lines[buf.CursorY] = buf.Lines[buf.CursorY] + buf.Lines[buf.CursorY+1]
+			buf.Lines = append(buf.Lines[:buf.CursorY+1], buf.Lines[buf.CursorY+2:]...)
+			buf.MarkDirtyLines(buf.CursorY, buf.CursorY)
+		}
+	}
+}
+
+func (e *Editor) InsertTab() {
+	buf := e.CurrentBuffer()
+	if e.readOnly(buf) {
+		return
+	}
+	if first, last := buf.selectedLines(); buf.Selection.Active && first < last {
+		e.ShiftLines(true)
+		return
+	}
+	if buf.Selection.Active {
+		buf.DeleteSelection()
+	}
+	if e.expandTab(buf) {
+		width := e.shiftWidth(buf)
+		col := e.charToVisualCol(buf, buf.CursorY, buf.CursorX)
+		e.InsertText(strings.Repeat(" ", width-col%width))
+	} else {
+		e.InsertText("\t")
+	}
+}
+
+func (e *Editor) InsertRune(r rune) {
+	buf := e.CurrentBuffer()
+	if e.readOnly(buf) {
+		return
+	}
+	if buf.Selection.Active {
+		buf.DeleteSelection()
+	}
+	runes := []rune(buf.Lines[buf.CursorY])
+	if buf.CursorX > len(runes) {
+		buf.CursorX = len(runes)
+	}
+	buf.Lines[buf.CursorY] = string(runes[:buf.CursorX]) + string(r) + string(runes[buf.CursorX:])
+	e.dedentClosing(buf, r)
+	buf.CursorX++
+	buf.MarkDirty()
+	e.ScrollToCursor(e.CurrentPane())
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// ansiView holds the raw text of a buffer rendered with ANSI SGR styles.
+// Buffer.Lines keeps the text with escapes stripped, so drawing and cursor
+// movement never see them.
+type ansiView struct {
+	Raw       []string
+	LastStart tcell.Style // SGR state at the start of the last line
+}
+
+func hasANSI(lines []string) bool {
+	for _, line := range lines {
+		if strings.Contains(line, "\x1b[") {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Buffer) EnableANSI() {
+	if b.ANSI != nil {
+		return
+	}
+	b.ANSI = &ansiView{Raw: b.Lines}
+	b.Lines = make([]string, len(b.Lines))
+	b.TokenCache = make([][]TokenInfo, len(b.Lines))
+	b.parseANSIFrom(0, tcell.StyleDefault)
+	b.clampCursor()
+}
+
+func (b *Buffer) DisableANSI() {
+	if b.ANSI == nil {
+		return
+	}
+	b.Lines = b.ANSI.Raw
+	b.ANSI = nil
+	b.TokenCache = nil
+	b.SetupHighlighting()
+	b.clampCursor()
+}
+
+func (b *Buffer) clampCursor() {
+	if b.CursorY >= len(b.Lines) {
+		b.CursorY = len(b.Lines) - 1
+	}
+	if lineLen := len([]rune(b.Lines[b.CursorY])); b.CursorX > lineLen {
+		b.CursorX = lineLen
+	}
+}
+
+// parseANSIFrom re-renders raw lines from index start onwards, starting in
+// the given SGR state.
+func (b *Buffer) parseANSIFrom(start int, style tcell.Style) {
+	for i := start; i < len(b.ANSI.Raw); i++ {
+		b.ANSI.LastStart = style
+		b.Lines[i], b.TokenCache[i], style = parseANSILine(b.ANSI.Raw[i], style)
+	}
+}
+
+// appendANSI extends the last raw line with parts[0] and adds the rest as new
+// lines, keeping the SGR state that was active across the line break.
+func (b *Buffer) appendANSI(parts []string) {
+	last := len(b.ANSI.Raw) - 1
+	b.ANSI.Raw[last] += parts[0]
+	b.ANSI.Raw = append(b.ANSI.Raw, parts[1:]...)
+	for len(b.Lines) < len(b.ANSI.Raw) {
+		b.Lines = append(b.Lines, "")
+		b.TokenCache = append(b.TokenCache, nil)
+	}
+	b.parseANSIFrom(last, b.ANSI.LastStart)
+}
+
+// parseANSILine strips escape sequences from raw and turns SGR sequences into
+// tokens. Token columns are rune offsets into the stripped text.
+func parseANSILine(raw string, style tcell.Style) (string, []TokenInfo, tcell.Style) {
+	var text strings.Builder
+	tokens := []TokenInfo{}
+	col := 0
+	runStart := 0
+
+	flush := func() {
+		if col > runStart && style != tcell.StyleDefault {
+			tokens = append(tokens, TokenInfo{Col: runStart, Len: col - runStart, Style: style})
+		}
+		runStart = col
+	}
+
+	for i := 0; i < len(raw); {
+		if raw[i] != 0x1b {
+			r, size := utf8.DecodeRuneInString(raw[i:])
+			text.WriteRune(r)
+			col++
+			i += size
+			continue
+		}
+
+		// ESC at end of line is dropped
+		if i+1 >= len(raw) {
+			break
+		}
+		switch raw[i+1] {
+		case '[':
+			// CSI: parameters, intermediates, one final byte in 0x40-0x7e
+			j := i + 2
+			for j < len(raw) && (raw[j] < 0x40 || raw[j] > 0x7e) {
+				j++
+			}
+			if j >= len(raw) {
+				i = len(raw)
+				continue
+			}
+			if raw[j] == 'm' {
+				flush()
+				style = applySGR(style, raw[i+2:j])
+			}
+			i = j + 1
+		case ']':
+			// OSC: terminated by BEL or ST (ESC \)
+			j := i + 2
+			for j < len(raw) && raw[j] != 0x07 && !(raw[j] == 0x1b && j+1 < len(raw) && raw[j+1] == '\\') {
+				j++
+			}
+			if j < len(raw) && raw[j] == 0x1b {
+				j++
+			}
+			i = j + 1
+		default:
+			// Intermediates then one final byte, like ESC ( B from tput sgr0
+			j := i + 1
+			for j < len(raw) && raw[j] >= 0x20 && raw[j] <= 0x2f {
+				j++
+			}
+			i = j + 1
+		}
+	}
+	flush()
+	return text.String(), tokens, style
+}
+
+func applySGR(style tcell.Style, params string) tcell.Style {
+	if params == "" {
+		return tcell.StyleDefault
+	}
+	fields := strings.FieldsFunc(params, func(r rune) bool { return r == ';' || r == ':' })
+	codes := make([]int, len(fields))
+	for i, f := range fields {
+		codes[i], _ = strconv.Atoi(f)
+	}
+
+	for i := 0; i < len(codes); i++ {
+		code := codes[i]
+		switch {
+		case code == 0:
+			style = tcell.StyleDefault
+		case code == 1:
+			style = style.Bold(true)
+		case code == 2:
+			style = style.Dim(true)
+		case code == 3:
+			style = style.Italic(true)
+		case code == 4:
+			style = style.Underline(true)
+		case code == 5:
+			style = style.Blink(true)
+		case code == 7:
+			style = style.Reverse(true)
+		case code == 9:
+			style = style.StrikeThrough(true)
+		case code == 22:
+			style = style.Bold(false).Dim(false)
+		case code == 23:
+			style = style.Italic(false)
+		case code == 24:
+			style = style.Underline(false)
+		case code == 25:
+			style = style.Blink(false)
+		case code == 27:
+			style = style.Reverse(false)
+		case code == 29:
+			style = style.StrikeThrough(false)
+		case code >= 30 && code <= 37:
+			style = style.Foreground(tcell.PaletteColor(code - 30))
+		case code == 38 || code == 48:
+			color, n := extendedColor(codes[i+1:])
+			i += n
+			if code == 38 {
+				style = style.Foreground(color)
+			} else {
+				style = style.Background(color)
+			}
+		case code == 39:
+			style = style.Foreground(tcell.ColorDefault)
+		case code >= 40 && code <= 47:
+			style = style.Background(tcell.PaletteColor(code - 40))
+		case code == 49:
+			style = style.Background(tcell.ColorDefault)
+		case code >= 90 && code <= 97:
+			style = style.Foreground(tcell.PaletteColor(code - 90 + 8))
+		case code >= 100 && code <= 107:
+			style = style.Background(tcell.PaletteColor(code - 100 + 8))
+		}
+	}
+	return style
+}
+
+// extendedColor decodes the arguments of a 38/48 sequence (5;n or 2;r;g;b)
+// and returns the colour and how many codes it consumed.
+func extendedColor(args []int) (tcell.Color, int) {
+	if len(args) >= 2 && args[0] == 5 {
+		return tcell.PaletteColor(args[1] & 0xff), 2
+	}
+	if len(args) >= 4 && args[0] == 2 {
+		return tcell.NewRGBColor(int32(args[1]), int32(args[2]), int32(args[3])), 4
+	}
+	return tcell.ColorDefault, len(args)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestParseANSILine(t *testing.T) {
+	red := tcell.StyleDefault.Foreground(tcell.PaletteColor(1))
+	tests := []struct {
+		name   string
+		raw    string
+		start  tcell.Style
+		text   string
+		tokens []TokenInfo
+		end    tcell.Style
+	}{
+		{"plain", "hello", tcell.StyleDefault, "hello", nil, tcell.StyleDefault},
+		{"reset", "\x1b[31mred\x1b[0m plain", tcell.StyleDefault, "red plain",
+			[]TokenInfo{{Col: 0, Len: 3, Style: red}}, tcell.StyleDefault},
+		{"empty reset", "\x1b[1;31mab\x1b[m", tcell.StyleDefault, "ab",
+			[]TokenInfo{{Col: 0, Len: 2, Style: red.Bold(true)}}, tcell.StyleDefault},
+		{"carried in", "ab\x1b[39mc", red, "abc",
+			[]TokenInfo{{Col: 0, Len: 2, Style: red}}, tcell.StyleDefault},
+		{"256 colours", "x\x1b[38;5;208my", tcell.StyleDefault, "xy",
+			[]TokenInfo{{Col: 1, Len: 1, Style: tcell.StyleDefault.Foreground(tcell.PaletteColor(208))}},
+			tcell.StyleDefault.Foreground(tcell.PaletteColor(208))},
+		{"truecolour", "\x1b[48;2;1;2;3mé\x1b[49m", tcell.StyleDefault, "é",
+			[]TokenInfo{{Col: 0, Len: 1, Style: tcell.StyleDefault.Background(tcell.NewRGBColor(1, 2, 3))}},
+			tcell.StyleDefault},
+		{"colon separated", "\x1b[38:2:10:20:30mz", tcell.StyleDefault, "z",
+			[]TokenInfo{{Col: 0, Len: 1, Style: tcell.StyleDefault.Foreground(tcell.NewRGBColor(10, 20, 30))}},
+			tcell.StyleDefault.Foreground(tcell.NewRGBColor(10, 20, 30))},
+		{"short 256 colours", "\x1b[38;5mz", tcell.StyleDefault, "z", nil, tcell.StyleDefault},
+		{"unterminated CSI", "a\x1b[31", tcell.StyleDefault, "a", nil, tcell.StyleDefault},
+		{"trailing ESC", "a\x1b", tcell.StyleDefault, "a", nil, tcell.StyleDefault},
+		{"cursor movement", "a\x1b[2Kb\x1b[1;1Hc", tcell.StyleDefault, "abc", nil, tcell.StyleDefault},
+		{"OSC with BEL", "\x1b]0;title\x07text", tcell.StyleDefault, "text", nil, tcell.StyleDefault},
+		{"OSC with ST", "\x1b]8;;http://x\x1b\\link", tcell.StyleDefault, "link", nil, tcell.StyleDefault},
+		{"charset escape", "\x1b[1ma\x1b(B\x1b[mb", tcell.StyleDefault, "ab",
+			[]TokenInfo{{Col: 0, Len: 1, Style: tcell.StyleDefault.Bold(true)}}, tcell.StyleDefault},
+		{"two-byte escape", "a\x1b7b\x1b8", tcell.StyleDefault, "ab", nil, tcell.StyleDefault},
+	}
+	for _, tt := range tests {
+		text, tokens, end := parseANSILine(tt.raw, tt.start)
+		if text != tt.text {
+			t.Errorf("%s: text %q, want %q", tt.name, text, tt.text)
+		}
+		if len(tokens) != len(tt.tokens) {
+			t.Errorf("%s: tokens %+v, want %+v", tt.name, tokens, tt.tokens)
+		} else {
+			for i, token := range tokens {
+				want := tt.tokens[i]
+				if token.Col != want.Col || token.Len != want.Len || token.Style != want.Style {
+					t.Errorf("%s: token %d is %+v, want %+v", tt.name, i, token, want)
+				}
+			}
+		}
+		if end != tt.end {
+			t.Errorf("%s: ends in style %v, want %v", tt.name, end, tt.end)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"strings"
+)
+
+// A block selection covers the same screen columns on every line from
+// StartLine to EndLine. Its StartCol and EndCol are visual columns, so it
+// keeps its shape across tabs and short lines.
+
+// blockMoves are the actions that grow a block selection, as line and
+// column steps.
+var blockMoves = map[string][2]int{
+	"select.up": {-1, 0}, "select.down": {1, 0}, "select.left": {0, -1}, "select.right": {0, 1},
+	"select.blockup": {-1, 0}, "select.blockdown": {1, 0}, "select.blockleft": {0, -1}, "select.blockright": {0, 1},
+}
+
+// blockActions work on a block selection as it is. Anything else turns it
+// into a cursor per line first.
+var blockActions = map[string]bool{
+	"selection.block": true,
+	"clipboard.copy":  true,
+	"clipboard.cut":   true,
+	"clipboard.paste": true,
+	"cancel":          true,
+}
+
+func (s Selection) blockRect() (top, bottom, left, right int) {
+	top, bottom = min(s.StartLine, s.EndLine), max(s.StartLine, s.EndLine)
+	left, right = min(s.StartCol, s.EndCol), max(s.StartCol, s.EndCol)
+	return top, bottom, left, right
+}
+
+// visualToCharCol returns the character at visual column col of line, or
+// the end of the line if it is shorter.
+func (e *Editor) visualToCharCol(buf *Buffer, line, col int) int {
+	tabWidth := e.tabWidth(buf)
+	visual := 0
+	for i, r := range []rune(buf.Line(line)) {
+		if visual >= col {
+			return i
+		}
+		if r == '\t' {
+			visual += tabWidth - visual%tabWidth
+		} else {
+			visual++
+		}
+	}
+	return buf.lineLen(line)
+}
+
+// blockColumns returns the characters [start, end) of line that are in
+// visual columns [left, right). A tab partly inside counts as inside.
+func (e *Editor) blockColumns(buf *Buffer, line, left, right int) (start, end int) {
+	tabWidth := e.tabWidth(buf)
+	runes := []rune(buf.Line(line))
+	start, end = len(runes), len(runes)
+	visual := 0
+	for i, r := range runes {
+		width := 1
+		if r == '\t' {
+			width = tabWidth - visual%tabWidth
+		}
+		if start == len(runes) && visual+width > left {
+			start = i
+		}
+		if visual >= right {
+			end = i
+			break
+		}
+		visual += width
+	}
+	return min(start, end), end
+}
+
+// SelectBlock grows the block selection by dy lines and dx columns,
+// starting one at the cursor if there is none.
+func (e *Editor) SelectBlock(dy, dx int) {
+	buf := e.CurrentBuffer()
+	if !buf.Selection.Active || !buf.Selection.Block {
+		col := e.charToVisualCol(buf, buf.CursorY, buf.CursorX)
+		buf.Selection = Selection{StartLine: buf.CursorY, StartCol: col, EndLine: buf.CursorY, EndCol: col, Active: true, Block: true}
+	}
+	s := &buf.Selection
+	s.EndLine = min(max(s.EndLine+dy, 0), buf.LineCount()-1)
+	s.EndCol = max(0, s.EndCol+dx)
+	buf.CursorY = s.EndLine
+	buf.CursorX = e.visualToCharCol(buf, s.EndLine, s.EndCol)
+	e.ScrollToCursor(e.CurrentPane())
+}
+
+// ToggleBlockSelection switches the selection between a stream and a block,
+// or starts an empty block at the cursor.
+func (e *Editor) ToggleBlockSelection() {
+	buf := e.CurrentBuffer()
+	s := &buf.Selection
+	switch {
+	case !s.Active:
+		e.SelectBlock(0, 0)
+	case s.Block:
+		s.Block = false
+		s.StartCol = e.visualToCharCol(buf, s.StartLine, s.StartCol)
+		s.EndCol = e.visualToCharCol(buf, s.EndLine, s.EndCol)
+		buf.CursorY, buf.CursorX = s.EndLine, s.EndCol
+	default:
+		s.Block = true
+		s.StartCol = e.charToVisualCol(buf, s.StartLine, s.StartCol)
+		s.EndCol = e.charToVisualCol(buf, s.EndLine, s.EndCol)
+	}
+	if s.Block {
+		e.StatusMsg = "Block selection"
+	} else {
+		e.StatusMsg = "Stream selection"
+	}
+}
+
+// blockText returns the block selection's lines joined by newlines.
+func (e *Editor) blockText() string {
+	buf := e.CurrentBuffer()
+	top, bottom, left, right := buf.Selection.blockRect()
+	var rows []string
+	for line := top; line <= bottom; line++ {
+		start, end := e.blockColumns(buf, line, left, right)
+		rows = append(rows, buf.textBetween(position{line, start}, position{line, end}))
+	}
+	return strings.Join(rows, "\n")
+}
+
+// blockToCursors replaces the block selection with a cursor on each of its
+// lines, selecting that line's part of it, so edits happen on every line.
+func (e *Editor) blockToCursors() {
+	buf := e.CurrentBuffer()
+	s := buf.Selection
+	top, bottom, left, right := s.blockRect()
+	atLeft := s.EndCol < s.StartCol
+	buf.Selection = Selection{}
+	var main Cursor
+	for line := top; line <= bottom; line++ {
+		start, end := e.blockColumns(buf, line, left, right)
+		c := Cursor{X: end, Y: line}
+		if atLeft {
+			c.X = start
+		}
+		if start < end {
+			c.Selection = Selection{StartLine: line, StartCol: start, EndLine: line, EndCol: end, Active: true}
+			if atLeft {
+				c.Selection.StartCol, c.Selection.EndCol = end, start
+			}
+		}
+		if line == s.EndLine {
+			main = c
+		} else {
+			buf.Cursors = append(buf.Cursors, c)
+		}
+	}
+	buf.setMainCursor(main)
+	buf.mergeCursors()
+}
+
+// pasteBlock puts the lines of text below each other at the cursor's
+// screen column, padding short lines and adding lines at the end as needed.
+func (e *Editor) pasteBlock(text string) {
+	buf := e.CurrentBuffer()
+	col := e.charToVisualCol(buf, buf.CursorY, buf.CursorX)
+	top := buf.CursorY
+	for i, row := range strings.Split(text, "\n") {
+		line := top + i
+		dirty := line
+		if line >= len(buf.Lines) {
+			buf.Lines = append(buf.Lines, "")
+			dirty--
+		}
+		if width := e.charToVisualCol(buf, line, buf.lineLen(line)); width < col {
+			buf.Lines[line] += strings.Repeat(" ", col-width)
+		}
+		runes := []rune(buf.Lines[line])
+		at := e.visualToCharCol(buf, line, col)
+		buf.Lines[line] = string(runes[:at]) + row + string(runes[at:])
+		buf.MarkDirtyLines(dirty, line)
+	}
+	e.ScrollToCursor(e.CurrentPane())
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+)
+
+const (
+	brackets = "()[]{}"
+	// bracketScanLines bounds how far a match is looked for, so a stray
+	// bracket in a huge file does not stall every redraw
+	bracketScanLines = 5000
+)
+
+// inCode reports whether the character at line, col is code rather than
+// part of a string or comment. Lines not lexed yet count as code.
+func (b *Buffer) inCode(line, col int) bool {
+	if line >= len(b.TokenCache) {
+		return true
+	}
+	for _, token := range b.TokenCache[line] {
+		if col >= token.Col && col < token.Col+token.Len {
+			return !token.Type.InCategory(chroma.LiteralString) && !token.Type.InCategory(chroma.Comment)
+		}
+	}
+	return true
+}
+
+// bracketAt returns the bracket at pos, if there is one outside strings and
+// comments.
+func (b *Buffer) bracketAt(pos position) (rune, bool) {
+	if pos.Line < 0 || pos.Line >= b.LineCount() || pos.Col < 0 {
+		return 0, false
+	}
+	runes := []rune(b.Line(pos.Line))
+	if pos.Col >= len(runes) || !strings.ContainsRune(brackets, runes[pos.Col]) {
+		return 0, false
+	}
+	return runes[pos.Col], b.inCode(pos.Line, pos.Col)
+}
+
+// cursorBracket finds the bracket the cursor is on, or else the one just
+// before it, as after typing a closing bracket.
+func (b *Buffer) cursorBracket() (position, bool) {
+	cur := position{b.CursorY, b.CursorX}
+	if _, ok := b.bracketAt(cur); ok {
+		return cur, true
+	}
+	before := position{cur.Line, cur.Col - 1}
+	_, ok := b.bracketAt(before)
+	return before, ok
+}
+
+// matchBracket returns the partner of the bracket at pos, skipping nested
+// pairs of the same kind and brackets in strings and comments.
+func (b *Buffer) matchBracket(pos position) (position, bool) {
+	r, ok := b.bracketAt(pos)
+	if !ok {
+		return position{}, false
+	}
+	i := strings.IndexRune(brackets, r)
+	opening, closing := rune(brackets[i&^1]), rune(brackets[i|1])
+	dir := 1
+	if r == closing {
+		dir = -1
+	}
+	depth := 0
+	last := min(b.LineCount()-1, pos.Line+bracketScanLines)
+	first := max(0, pos.Line-bracketScanLines)
+	for line := pos.Line; line >= first && line <= last; line += dir {
+		runes := []rune(b.Line(line))
+		col := 0
+		if dir < 0 {
+			col = len(runes) - 1
+		}
+		if line == pos.Line {
+			col = pos.Col
+		}
+		for ; col >= 0 && col < len(runes); col += dir {
+			c := runes[col]
+			if c != opening && c != closing || !b.inCode(line, col) {
+				continue
+			}
+			if c == r {
+				depth++
+			} else if depth--; depth == 0 {
+				return position{line, col}, true
+			}
+		}
+	}
+	return position{}, false
+}
+
+// JumpToBracket moves the cursor to the partner of the bracket it is on.
+func (e *Editor) JumpToBracket() {
+	buf := e.CurrentBuffer()
+	at, ok := buf.cursorBracket()
+	if !ok {
+		e.StatusMsg = "Not on a bracket"
+		e.motionFailed = true
+		return
+	}
+	match, ok := buf.matchBracket(at)
+	if !ok {
+		e.StatusMsg = "No matching bracket"
+		e.motionFailed = true
+		return
+	}
+	buf.Selection.Active = false
+	buf.CursorY, buf.CursorX = match.Line, match.Col
+	e.ScrollToCursor(e.CurrentPane())
+}
+
+// bracketMark is a bracket to highlight, the partner of the one at the
+// cursor or one without a partner.
+type bracketMark struct {
+	pos       position
+	unmatched bool
+}
+
+// bracketMarks returns the brackets to highlight around buf's cursor.
+func (b *Buffer) bracketMarks() []bracketMark {
+	if b.Hex != nil || b.Large != nil {
+		return nil
+	}
+	at, ok := b.cursorBracket()
+	if !ok {
+		return nil
+	}
+	match, ok := b.matchBracket(at)
+	if !ok {
+		return []bracketMark{{pos: at, unmatched: true}}
+	}
+	return []bracketMark{{pos: at}, {pos: match}}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/atotto/clipboard"
+	"github.com/gdamore/tcell/v2"
+)
+
+const clipboardTimeout = 2 * time.Second
+
+// errUnsupported is returned by providers for what they cannot do, such as
+// reading back an OSC 52 copy. accela's own copy is used instead.
+var errUnsupported = errors.New("not supported")
+
+// clipboardProvider moves text to and from a system clipboard. primary picks
+// the X11 PRIMARY selection instead of CLIPBOARD.
+type clipboardProvider interface {
+	Name() string
+	Read(primary bool) (string, error)
+	Write(text string, primary bool) error
+}
+
+// commandClipboard runs a program to copy and paste, e.g. xclip.
+type commandClipboard struct {
+	name         string
+	copy, paste  []string
+	copyPrimary  []string // nil if the program has no PRIMARY selection
+	pastePrimary []string
+}
+
+var commandClipboards = []*commandClipboard{
+	{
+		name:         "wl-clipboard",
+		copy:         []string{"wl-copy"},
+		paste:        []string{"wl-paste", "--no-newline"},
+		copyPrimary:  []string{"wl-copy", "--primary"},
+		pastePrimary: []string{"wl-paste", "--no-newline", "--primary"},
+	},
+	{
+		name:         "xclip",
+		copy:         []string{"xclip", "-selection", "clipboard", "-in"},
+		paste:        []string{"xclip", "-selection", "clipboard", "-out"},
+		copyPrimary:  []string{"xclip", "-selection", "primary", "-in"},
+		pastePrimary: []string{"xclip", "-selection", "primary", "-out"},
+	},
+	{
+		name:         "xsel",
+		copy:         []string{"xsel", "--clipboard", "--input"},
+		paste:        []string{"xsel", "--clipboard", "--output"},
+		copyPrimary:  []string{"xsel", "--primary", "--input"},
+		pastePrimary: []string{"xsel", "--primary", "--output"},
+	},
+	{
+		name:  "tmux",
+		copy:  []string{"tmux", "load-buffer", "-"},
+		paste: []string{"tmux", "save-buffer", "-"},
+	},
+	{
+		name:  "pbcopy",
+		copy:  []string{"pbcopy"},
+		paste: []string{"pbpaste"},
+	},
+}
+
+func (c *commandClipboard) Name() string { return c.name }
+
+func (c *commandClipboard) available() bool {
+	_, err := exec.LookPath(c.copy[0])
+	return err == nil
+}
+
+func (c *commandClipboard) Read(primary bool) (string, error) {
+	args := c.paste
+	if primary {
+		if args = c.pastePrimary; args == nil {
+			return "", errUnsupported
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), clipboardTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("%s: %v", args[0], err)
+	}
+	return string(out), nil
+}
+
+func (c *commandClipboard) Write(text string, primary bool) error {
+	args := c.copy
+	if primary {
+		if args = c.copyPrimary; args == nil {
+			return errUnsupported
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), clipboardTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	// No output pipes: xclip and wl-copy stay in the background holding
+	// them open, and waiting for them would hang
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %v", args[0], err)
+	}
+	return nil
+}
+
+// osc52Clipboard asks the terminal to copy with the OSC 52 escape, which
+// works over SSH. Terminals do not let it read.
+type osc52Clipboard struct {
+	screen tcell.Screen
+}
+
+func (c *osc52Clipboard) Name() string { return "osc52" }
+
+func (c *osc52Clipboard) Read(primary bool) (string, error) {
+	return "", errUnsupported
+}
+
+func (c *osc52Clipboard) Write(text string, primary bool) error {
+	if primary {
+		return errUnsupported
+	}
+	c.screen.SetClipboard([]byte(text))
+	return nil
+}
+
+// systemClipboard uses the operating system's clipboard API where there is
+// no program to run, i.e. on Windows.
+type systemClipboard struct{}
+
+func (systemClipboard) Name() string { return "system" }
+
+func (systemClipboard) Read(primary bool) (string, error) {
+	if primary {
+		return "", errUnsupported
+	}
+	return clipboard.ReadAll()
+}
+
+func (systemClipboard) Write(text string, primary bool) error {
+	if primary {
+		return errUnsupported
+	}
+	return clipboard.WriteAll(text)
+}
+
+func clipboardNames() []string {
+	names := []string{"auto", "internal", "osc52"}
+	for _, c := range commandClipboards {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// newClipboard returns the provider named by the clipboard setting. "auto"
+// (or "system") picks one for the session: Wayland, X11 and tmux programs
+// first, then OSC 52. "internal" keeps copies inside accela.
+func (e *Editor) newClipboard(name string) (clipboardProvider, error) {
+	switch name {
+	case "internal":
+		return nil, nil
+	case "osc52":
+		return &osc52Clipboard{e.Screen}, nil
+	case "auto", "system":
+		return e.detectClipboard(), nil
+	}
+	for _, c := range commandClipboards {
+		if c.name == name {
+			if !c.available() {
+				return nil, fmt.Errorf("%s is not installed", c.copy[0])
+			}
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown clipboard %q (%s)", name, strings.Join(clipboardNames(), ", "))
+}
+
+func (e *Editor) detectClipboard() clipboardProvider {
+	env := func(name string) bool { return os.Getenv(name) != "" }
+	find := func(name string) clipboardProvider {
+		for _, c := range commandClipboards {
+			if c.name == name && c.available() {
+				return c
+			}
+		}
+		return nil
+	}
+	var found clipboardProvider
+	switch {
+	case runtime.GOOS == "windows":
+		return systemClipboard{}
+	case runtime.GOOS == "darwin":
+		found = find("pbcopy")
+	case env("WAYLAND_DISPLAY"):
+		found = find("wl-clipboard")
+	}
+	if found == nil && env("DISPLAY") {
+		if found = find("xclip"); found == nil {
+			found = find("xsel")
+		}
+	}
+	if found == nil && env("TMUX") {
+		found = find("tmux")
+	}
+	if found == nil {
+		found = &osc52Clipboard{e.Screen}
+	}
+	return found
+}
+
+// writeClipboard keeps a copy of text inside the editor as well, so paste
+// still works when the system clipboard is unavailable. Errors are shown in
+// the status bar as well as returned.
+func (e *Editor) writeClipboard(text string) error {
+	e.pushClipRing(text)
+	if e.register != 0 {
+		e.setRegister(e.register, text)
+		return nil
+	}
+	e.clipboardText = text
+	return e.writeProvider(text, false)
+}
+
+// readClipboard falls back to accela's own copy if the provider cannot
+// read, along with why if it failed.
+func (e *Editor) readClipboard() (string, error) {
+	if e.register != 0 {
+		return e.registers[unicode.ToLower(e.register)], nil
+	}
+	return e.readProvider(e.clipboardText, false)
+}
+
+// writePrimary sets the PRIMARY selection, which X11 pastes with the
+// middle button.
+func (e *Editor) writePrimary(text string) {
+	e.primaryText = text
+	e.writeProvider(text, true)
+}
+
+func (e *Editor) readPrimary() (string, error) {
+	return e.readProvider(e.primaryText, true)
+}
+
+func (e *Editor) writeProvider(text string, primary bool) error {
+	if e.clipboard == nil {
+		return nil
+	}
+	err := e.clipboard.Write(text, primary)
+	if err == nil || errors.Is(err, errUnsupported) {
+		return nil
+	}
+	e.StatusMsg = fmt.Sprintf("Error: clipboard: %v", err)
+	return err
+}
+
+func (e *Editor) readProvider(own string, primary bool) (string, error) {
+	if e.clipboard == nil {
+		return own, nil
+	}
+	text, err := e.clipboard.Read(primary)
+	if errors.Is(err, errUnsupported) {
+		return own, nil
+	} else if err != nil {
+		return own, fmt.Errorf("clipboard: %v", err)
+	}
+	return text, nil
+}
+
+// copyToClipboard writes text and reports it, unless writing failed.
+func (e *Editor) copyToClipboard(text, msg string) {
+	if e.writeClipboard(text) == nil {
+		e.StatusMsg = e.clipboardMsg(msg)
+	}
+}
+
+// clipboardMsg names the register in msg if one is used instead of the
+// clipboard.
+func (e *Editor) clipboardMsg(msg string) string {
+	if e.register == 0 {
+		return msg
+	}
+	return strings.Replace(msg, "clipboard", fmt.Sprintf("register %c", unicode.ToLower(e.register)), 1)
+}
+
+// PastePrimary pastes the PRIMARY selection at the cursor.
+func (e *Editor) PastePrimary() {
+	text, err := e.readPrimary()
+	if text != "" {
+		e.InsertPasted(text)
+	}
+	if err != nil {
+		e.StatusMsg = fmt.Sprintf("Error: %v", err)
+	}
+}
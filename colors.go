@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+const trueColor = 1 << 24
+
+// paletteScreen maps every color down to what the terminal can show before
+// it reaches tcell, so RGB theme colors turn into the nearest palette entry
+// instead of escape sequences the terminal does not understand.
+type paletteScreen struct {
+	tcell.Screen
+	Detected int
+	depth    int
+	palette  []tcell.Color
+	mapped   map[tcell.Color]tcell.Color
+}
+
+func newPaletteScreen(screen tcell.Screen) *paletteScreen {
+	s := &paletteScreen{Screen: screen, Detected: detectColorDepth(screen.Colors())}
+	s.SetDepth(s.Detected)
+	return s
+}
+
+// detectColorDepth trusts terminfo for the palette size but only goes
+// truecolor when the terminal says so through COLORTERM or TERM; terminfo
+// alone often claims RGB through tmux or screen when it will not work.
+func detectColorDepth(terminfoColors int) int {
+	if terminfoColors == 0 {
+		return 0
+	}
+	switch os.Getenv("COLORTERM") {
+	case "truecolor", "24bit":
+		return trueColor
+	}
+	term := os.Getenv("TERM")
+	if strings.HasSuffix(term, "-direct") || strings.Contains(term, "truecolor") || strings.Contains(term, "24bit") {
+		return trueColor
+	}
+	switch {
+	case terminfoColors >= 256:
+		return 256
+	case terminfoColors >= 16:
+		return 16
+	}
+	return 8
+}
+
+func (s *paletteScreen) Depth() int {
+	return s.depth
+}
+
+func (s *paletteScreen) SetDepth(depth int) {
+	s.depth = depth
+	s.mapped = make(map[tcell.Color]tcell.Color)
+	s.palette = nil
+	if depth < trueColor {
+		for i := 0; i < depth; i++ {
+			s.palette = append(s.palette, tcell.PaletteColor(i))
+		}
+	}
+}
+
+func (s *paletteScreen) mapColor(c tcell.Color) tcell.Color {
+	if s.depth >= trueColor || !c.Valid() || c&tcell.ColorSpecial != 0 {
+		return c
+	}
+	if s.depth == 0 {
+		return tcell.ColorDefault
+	}
+	if !c.IsRGB() && int(c-tcell.ColorValid) < s.depth {
+		return c
+	}
+	if m, ok := s.mapped[c]; ok {
+		return m
+	}
+	m := tcell.FindColor(c, s.palette)
+	s.mapped[c] = m
+	return m
+}
+
+func (s *paletteScreen) SetContent(x, y int, primary rune, combining []rune, style tcell.Style) {
+	if s.depth < trueColor {
+		fg, bg, _ := style.Decompose()
+		style = style.Foreground(s.mapColor(fg)).Background(s.mapColor(bg))
+	}
+	s.Screen.SetContent(x, y, primary, combining, style)
+}
+
+func colorDepthName(depth int) string {
+	switch depth {
+	case trueColor:
+		return "truecolor"
+	case 0:
+		return "none"
+	}
+	return fmt.Sprint(depth)
+}
+
+// SetColorDepth overrides the detected color depth; "auto" goes back to it.
+func (e *Editor) SetColorDepth(arg string) error {
+	ps, ok := e.Screen.(*paletteScreen)
+	if !ok {
+		return fmt.Errorf("color depth cannot be changed on this screen")
+	}
+	depth := ps.Detected
+	switch arg {
+	case "auto":
+	case "truecolor", "24bit":
+		depth = trueColor
+	case "256", "16", "8":
+		depth, _ = strconv.Atoi(arg)
+	case "none", "0":
+		depth = 0
+	default:
+		return fmt.Errorf("unknown color depth %q (auto, truecolor, 256, 16, 8, none)", arg)
+	}
+	ps.SetDepth(depth)
+	// Everything on screen was mapped for the old depth
+	e.Screen.Clear()
+	e.InvalidateFrames()
+	return nil
+}
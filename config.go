@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config holds the user's settings, read from config.toml (or config.json)
+// in the accela config directory.
+type Config struct {
+	TabWidth      int               `toml:"tabwidth" json:"tabwidth"`
+	ExpandTab     bool              `toml:"expandtab" json:"expandtab"`
+	ShiftWidth    int               `toml:"shiftwidth" json:"shiftwidth"` // 0 for the tab width
+	AutoIndent    bool              `toml:"autoindent" json:"autoindent"`
+	Theme         string            `toml:"theme" json:"theme"`
+	Colors        string            `toml:"colors" json:"colors"`
+	LineNumbers   bool              `toml:"linenumbers" json:"linenumbers"`
+	AutoSave      bool              `toml:"autosave" json:"autosave"`
+	Clipboard     string            `toml:"clipboard" json:"clipboard"`
+	Keys          string            `toml:"keys" json:"keys"`
+	Mouse         bool              `toml:"mouse" json:"mouse"`
+	SaveRegisters bool              `toml:"saveregisters" json:"saveregisters"`
+	Keymap        map[string]string `toml:"keymap" json:"keymap"`
+	Macros        map[string]string `toml:"macros" json:"macros"` // register to keys, e.g. a = "ctrl+e ; down"
+}
+
+func defaultConfig() Config {
+	return Config{
+		TabWidth:    4,
+		AutoIndent:  true,
+		Theme:       defaultColorscheme,
+		Colors:      "auto",
+		LineNumbers: true,
+		Clipboard:   "auto",
+		Keys:        "default",
+		Mouse:       true,
+	}
+}
+
+func configDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "accela")
+}
+
+// readConfig returns the defaults overlaid with the config file, if there is
+// one. A file that does not parse leaves the defaults alone.
+func readConfig() (Config, error) {
+	cfg := defaultConfig()
+	dir := configDir()
+	if dir == "" {
+		return cfg, nil
+	}
+	path := filepath.Join(dir, "config.toml")
+	if _, err := toml.DecodeFile(path, &cfg); err == nil {
+		return cfg, nil
+	} else if !os.IsNotExist(err) {
+		return defaultConfig(), err
+	}
+
+	path = filepath.Join(dir, "config.json")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return defaultConfig(), fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// LoadConfig reads the config file and applies it. Settings changed with set
+// since the last load are overwritten.
+func (e *Editor) LoadConfig() error {
+	cfg, err := readConfig()
+	e.Config = cfg
+	if applyErr := e.applyConfig(); err == nil {
+		err = applyErr
+	}
+	return err
+}
+
+// applyConfig checks the settings read from the file and pushes the ones
+// that live outside Config to where they are used. Bad values fall back to
+// their defaults.
+func (e *Editor) applyConfig() error {
+	var errs []string
+	defaults := &Editor{Config: defaultConfig()}
+	for _, name := range []string{"tabwidth", "theme", "colors", "clipboard", "keys", "mouse"} {
+		opt := configOptions[name]
+		if err := opt.set(e, opt.get(e)); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			opt.set(e, opt.get(defaults))
+		}
+	}
+	if err := e.loadKeymap(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := e.loadMacros(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+type configOption struct {
+	get func(e *Editor) string
+	set func(e *Editor, value string) error
+}
+
+func boolOption(field func(c *Config) *bool) configOption {
+	return configOption{
+		get: func(e *Editor) string { return strconv.FormatBool(*field(&e.Config)) },
+		set: func(e *Editor, value string) error {
+			v, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("expected true or false, got %q", value)
+			}
+			*field(&e.Config) = v
+			return nil
+		},
+	}
+}
+
+var configOptions map[string]configOption
+
+func init() {
+	configOptions = map[string]configOption{
+		"tabwidth": {
+			get: func(e *Editor) string { return strconv.Itoa(e.Config.TabWidth) },
+			set: func(e *Editor, value string) error {
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 1 || n > 16 {
+					return fmt.Errorf("must be between 1 and 16")
+				}
+				e.Config.TabWidth = n
+				return nil
+			},
+		},
+		"shiftwidth": {
+			get: func(e *Editor) string { return strconv.Itoa(e.Config.ShiftWidth) },
+			set: func(e *Editor, value string) error {
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 || n > 16 {
+					return fmt.Errorf("must be between 0 (the tab width) and 16")
+				}
+				e.Config.ShiftWidth = n
+				return nil
+			},
+		},
+		"expandtab":     boolOption(func(c *Config) *bool { return &c.ExpandTab }),
+		"autoindent":    boolOption(func(c *Config) *bool { return &c.AutoIndent }),
+		"linenumbers":   boolOption(func(c *Config) *bool { return &c.LineNumbers }),
+		"autosave":      boolOption(func(c *Config) *bool { return &c.AutoSave }),
+		"saveregisters": boolOption(func(c *Config) *bool { return &c.SaveRegisters }),
+		"mouse": {
+			get: func(e *Editor) string { return strconv.FormatBool(e.Config.Mouse) },
+			set: func(e *Editor, value string) error {
+				if err := boolOption(func(c *Config) *bool { return &c.Mouse }).set(e, value); err != nil {
+					return err
+				}
+				e.applyMouse()
+				return nil
+			},
+		},
+		"theme": {
+			get: func(e *Editor) string { return e.Config.Theme },
+			set: func(e *Editor, value string) error {
+				theme, err := LoadTheme(value)
+				if err != nil {
+					return err
+				}
+				e.Config.Theme = value
+				e.SetTheme(theme)
+				return nil
+			},
+		},
+		"colors": {
+			get: func(e *Editor) string { return e.Config.Colors },
+			set: func(e *Editor, value string) error {
+				if _, ok := e.Screen.(*paletteScreen); !ok {
+					// Nothing to map on a screen we did not wrap
+					e.Config.Colors = value
+					return nil
+				}
+				if err := e.SetColorDepth(value); err != nil {
+					return err
+				}
+				e.Config.Colors = value
+				return nil
+			},
+		},
+		"keys": {
+			get: func(e *Editor) string { return e.Config.Keys },
+			set: func(e *Editor, value string) error {
+				if _, ok := keyProfiles[value]; !ok {
+					return fmt.Errorf("unknown key profile %q (default, vi, emacs)", value)
+				}
+				if value != e.Config.Keys {
+					e.vi = viState{}
+					e.CurrentBuffer().Selection.Active = false
+					e.Config.Keys = value
+					e.loadKeymap()
+				}
+				return nil
+			},
+		},
+		"clipboard": {
+			get: func(e *Editor) string { return e.Config.Clipboard },
+			set: func(e *Editor, value string) error {
+				provider, err := e.newClipboard(value)
+				if err != nil {
+					return err
+				}
+				e.Config.Clipboard = value
+				e.clipboard = provider
+				return nil
+			},
+		},
+	}
+}
+
+func optionNames() []string {
+	var names []string
+	for name := range configOptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetOption handles the argument of the set command: "name=value" sets,
+// "name?" queries, and a bare "name" or "noname" switches a boolean on or
+// off.
+func (e *Editor) SetOption(arg string) (string, error) {
+	if name, ok := strings.CutSuffix(arg, "?"); ok {
+		opt, ok := configOptions[name]
+		if !ok {
+			return "", fmt.Errorf("unknown option %q", name)
+		}
+		return fmt.Sprintf("%s=%s", name, opt.get(e)), nil
+	}
+
+	name, value, hasValue := strings.Cut(arg, "=")
+	if !hasValue {
+		value = "true"
+		if _, ok := configOptions[name]; !ok && strings.HasPrefix(name, "no") {
+			name, value = name[2:], "false"
+		}
+	}
+	opt, ok := configOptions[name]
+	if !ok {
+		return "", fmt.Errorf("unknown option %q", name)
+	}
+	if err := opt.set(e, value); err != nil {
+		return "", fmt.Errorf("%s: %v", name, err)
+	}
+	return fmt.Sprintf("%s=%s", name, opt.get(e)), nil
+}
+
+// SetLocalOption is SetOption for the settings the current buffer can have
+// of its own: tabwidth, shiftwidth and expandtab.
+func (e *Editor) SetLocalOption(arg string) (string, error) {
+	buf := e.CurrentBuffer()
+	query := strings.HasSuffix(arg, "?")
+	name, value, hasValue := strings.Cut(strings.TrimSuffix(arg, "?"), "=")
+	if name == "noexpandtab" && !hasValue {
+		name, value, hasValue = "expandtab", "false", true
+	} else if name == "expandtab" && !hasValue && !query {
+		value, hasValue = "true", true
+	}
+	switch name {
+	case "tabwidth", "shiftwidth":
+		if !hasValue {
+			break
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 16 {
+			return "", fmt.Errorf("%s: must be between 1 and 16", name)
+		}
+		if name == "shiftwidth" {
+			buf.ShiftWidth = n
+		} else {
+			buf.TabWidth = n
+			// Tabs are drawn wider or narrower now
+			e.InvalidateFrames()
+		}
+	case "expandtab":
+		if !hasValue {
+			break
+		}
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("expandtab: expected true or false, got %q", value)
+		}
+		buf.ExpandTab = &v
+	default:
+		return "", fmt.Errorf("unknown local option %q (tabwidth, shiftwidth, expandtab)", name)
+	}
+	switch name {
+	case "tabwidth":
+		return fmt.Sprintf("tabwidth=%d", e.tabWidth(buf)), nil
+	case "shiftwidth":
+		return fmt.Sprintf("shiftwidth=%d", e.shiftWidth(buf)), nil
+	}
+	return fmt.Sprintf("expandtab=%t", e.expandTab(buf)), nil
+}
+
+// autoSave writes buf if autosave is on and it has unsaved changes to a file.
+func (e *Editor) autoSave(buf *Buffer) error {
+	if !e.Config.AutoSave || !buf.Modified || buf.Filename == "" || buf.ReadOnly {
+		return nil
+	}
+	if err := buf.SaveFile(); err != nil {
+		e.StatusMsg = fmt.Sprintf("Error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (e *Editor) autoSaveAll() error {
+	for _, pane := range e.Panes {
+		if err := e.autoSave(pane.Buffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
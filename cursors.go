@@ -0,0 +1,470 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Cursor is a cursor besides the buffer's main one, which stays in
+// CursorX, CursorY and Selection so everything else can ignore the rest.
+type Cursor struct {
+	X, Y      int
+	Selection Selection
+}
+
+// perCursorActions are the actions that run at every cursor.
+var perCursorActions = map[string]bool{
+	"move.up": true, "move.down": true, "move.left": true, "move.right": true,
+	"move.wordleft": true, "move.wordright": true,
+	"move.linestart": true, "move.lineend": true, "move.home": true, "move.end": true,
+	"select.home": true, "select.end": true,
+	"select.up": true, "select.down": true, "select.left": true, "select.right": true,
+	"select.wordleft": true, "select.wordright": true,
+	"newline": true, "delete.back": true, "delete.forward": true, "insert.tab": true,
+	"indent.more": true, "indent.less": true,
+}
+
+func (b *Buffer) mainCursor() Cursor {
+	return Cursor{X: b.CursorX, Y: b.CursorY, Selection: b.Selection}
+}
+
+func (b *Buffer) setMainCursor(c Cursor) {
+	b.CursorX, b.CursorY, b.Selection = c.X, c.Y, c.Selection
+}
+
+// addCursor makes c the main cursor, keeping the old one as an extra.
+func (b *Buffer) addCursor(c Cursor) {
+	b.Cursors = append(b.Cursors, b.mainCursor())
+	b.setMainCursor(c)
+	b.mergeCursors()
+}
+
+func (c Cursor) pos() position {
+	return position{c.Y, c.X}
+}
+
+// span returns the text c covers: its selection, or just where it is.
+func (c Cursor) span() (start, end position) {
+	if !c.Selection.Active {
+		return c.pos(), c.pos()
+	}
+	start = position{c.Selection.StartLine, c.Selection.StartCol}
+	end = position{c.Selection.EndLine, c.Selection.EndCol}
+	if end.before(start) {
+		start, end = end, start
+	}
+	return start, end
+}
+
+// overlaps reports whether c and d sit on the same place or their
+// selections share text.
+func (c Cursor) overlaps(d Cursor) bool {
+	if c.pos() == d.pos() {
+		return true
+	}
+	cs, ce := c.span()
+	ds, de := d.span()
+	return cs.before(de) && ds.before(ce)
+}
+
+// merge folds d into c, covering both selections.
+func (c Cursor) merge(d Cursor) Cursor {
+	if !c.Selection.Active && !d.Selection.Active {
+		return c
+	}
+	cs, ce := c.span()
+	ds, de := d.span()
+	start, end := cs, ce
+	if ds.before(start) {
+		start = ds
+	}
+	if end.before(de) {
+		end = de
+	}
+	c.Selection = Selection{StartLine: start.Line, StartCol: start.Col, EndLine: end.Line, EndCol: end.Col, Active: true}
+	if c.pos() == cs {
+		// The cursor was at the start of its selection, keep it there
+		c.Selection.StartLine, c.Selection.StartCol, c.Selection.EndLine, c.Selection.EndCol = end.Line, end.Col, start.Line, start.Col
+		c.Y, c.X = start.Line, start.Col
+	} else {
+		c.Y, c.X = end.Line, end.Col
+	}
+	return c
+}
+
+// mergeCursors folds cursors that ran into each other into one, the main
+// cursor winning over the rest.
+func (b *Buffer) mergeCursors() {
+	main := b.mainCursor()
+	var kept []Cursor
+	for _, c := range b.Cursors {
+		if c.overlaps(main) {
+			main = main.merge(c)
+			continue
+		}
+		merged := false
+		for i := range kept {
+			if kept[i].overlaps(c) {
+				kept[i] = kept[i].merge(c)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			kept = append(kept, c)
+		}
+	}
+	b.setMainCursor(main)
+	b.Cursors = kept
+}
+
+// cursorsInOrder returns every cursor top to bottom and where the main one
+// ended up among them.
+func (b *Buffer) cursorsInOrder() ([]Cursor, int) {
+	all := append([]Cursor{b.mainCursor()}, b.Cursors...)
+	main := all[0]
+	sort.SliceStable(all, func(i, j int) bool { return all[i].pos().before(all[j].pos()) })
+	for i, c := range all {
+		if c == main {
+			return all, i
+		}
+	}
+	return all, 0
+}
+
+// endOffset is a position counted back from the end of the buffer, which an
+// edit before it does not change.
+type endOffset struct {
+	lines, cols int
+}
+
+func (b *Buffer) toEndOffset(p position) endOffset {
+	line := min(p.Line, b.LineCount()-1)
+	return endOffset{b.LineCount() - 1 - line, b.lineLen(line) - p.Col}
+}
+
+func (b *Buffer) fromEndOffset(o endOffset) position {
+	line := max(0, b.LineCount()-1-o.lines)
+	return position{line, max(0, b.lineLen(line)-o.cols)}
+}
+
+func (b *Buffer) cursorToEndOffsets(c Cursor) [3]endOffset {
+	return [3]endOffset{
+		b.toEndOffset(c.pos()),
+		b.toEndOffset(position{c.Selection.StartLine, c.Selection.StartCol}),
+		b.toEndOffset(position{c.Selection.EndLine, c.Selection.EndCol}),
+	}
+}
+
+func (b *Buffer) cursorFromEndOffsets(c Cursor, o [3]endOffset) Cursor {
+	p := b.fromEndOffset(o[0])
+	c.Y, c.X = p.Line, p.Col
+	start, end := b.fromEndOffset(o[1]), b.fromEndOffset(o[2])
+	c.Selection.StartLine, c.Selection.StartCol = start.Line, start.Col
+	c.Selection.EndLine, c.Selection.EndCol = end.Line, end.Col
+	return c
+}
+
+// forEachCursor runs fn once per cursor, top to bottom, with that cursor
+// loaded as the main one. fn edits at or before its own cursor, so the
+// cursors below it are carried along by their distance from the end of the
+// buffer.
+func (e *Editor) forEachCursor(fn func()) {
+	buf := e.CurrentBuffer()
+	all, main := buf.cursorsInOrder()
+	for i := range all {
+		later := make([][3]endOffset, len(all)-i-1)
+		for j := range later {
+			later[j] = buf.cursorToEndOffsets(all[i+1+j])
+		}
+		buf.setMainCursor(all[i])
+		fn()
+		all[i] = buf.mainCursor()
+		for j := range later {
+			all[i+1+j] = buf.cursorFromEndOffsets(all[i+1+j], later[j])
+		}
+	}
+	buf.setMainCursor(all[main])
+	buf.Cursors = append(all[:main:main], all[main+1:]...)
+	buf.mergeCursors()
+	e.ScrollToCursor(e.CurrentPane())
+}
+
+// stepActions are the moves that fail at the edge of the buffer. They stop
+// a macro being played.
+var stepActions = map[string]bool{
+	"up": true, "down": true, "left": true, "right": true,
+	"wordleft": true, "wordright": true, "pageup": true, "pagedown": true,
+	"blockup": true, "blockdown": true, "blockleft": true, "blockright": true,
+}
+
+// runAction runs the action bound to a key, at every cursor if it is one
+// that moves or edits at the cursor.
+func (e *Editor) runAction(action string) {
+	if action != "register.select" {
+		// A register picked with a prefix is for this action only
+		defer func() { e.register = 0 }()
+	}
+	if e.CurrentBuffer().Hex != nil && perCursorActions[action] {
+		e.runHexAction(action)
+		return
+	}
+	if stepActions[strings.TrimPrefix(strings.TrimPrefix(action, "move."), "select.")] {
+		// A step that goes nowhere, like Down on the last line, fails
+		buf := e.CurrentBuffer()
+		x, y := buf.CursorX, buf.CursorY
+		defer func() {
+			if b := e.CurrentBuffer(); b == buf && b.CursorX == x && b.CursorY == y {
+				e.motionFailed = true
+			}
+		}()
+	}
+	if sel := e.CurrentBuffer().Selection; sel.Active && sel.Block {
+		step, ok := blockMoves[action]
+		if !ok && sel.Mark {
+			step, ok = blockMoves["select."+strings.TrimPrefix(action, "move.")]
+		}
+		if ok {
+			e.SelectBlock(step[0], step[1])
+			return
+		}
+		if !blockActions[action] {
+			e.blockToCursors()
+		}
+	}
+	if len(e.CurrentBuffer().Cursors) > 0 && perCursorActions[action] {
+		e.forEachCursor(func() { editorActions[action](e) })
+		return
+	}
+	editorActions[action](e)
+}
+
+// typeRune inserts r at every cursor.
+func (e *Editor) typeRune(r rune) {
+	buf := e.CurrentBuffer()
+	if buf.Selection.Active && buf.Selection.Block {
+		e.blockToCursors()
+	}
+	if len(buf.Cursors) > 0 {
+		e.forEachCursor(func() { e.InsertRune(r) })
+	} else {
+		e.InsertRune(r)
+	}
+}
+
+// wordAt returns the start and end of the word under or just before col.
+func wordAt(runes []rune, col int) (int, int, bool) {
+	if col >= len(runes) || !isWordChar(runes[col]) {
+		if col == 0 || col > len(runes) || !isWordChar(runes[col-1]) {
+			return 0, 0, false
+		}
+		col--
+	}
+	start, end := col, col+1
+	for start > 0 && isWordChar(runes[start-1]) {
+		start--
+	}
+	for end < len(runes) && isWordChar(runes[end]) {
+		end++
+	}
+	return start, end, true
+}
+
+// AddCursorAtNextMatch selects the word under the cursor, or if something
+// is selected adds a cursor selecting the next place it appears.
+func (e *Editor) AddCursorAtNextMatch() {
+	buf := e.CurrentBuffer()
+	start, end, ok := buf.region()
+	if !ok || start == end {
+		runes := []rune(buf.Line(buf.CursorY))
+		s, t, ok := wordAt(runes, buf.CursorX)
+		if !ok {
+			return
+		}
+		buf.Selection = Selection{StartLine: buf.CursorY, StartCol: s, EndLine: buf.CursorY, EndCol: t, Active: true}
+		buf.CursorX = t
+		return
+	}
+	text := buf.textBetween(start, end)
+	from := end
+	var first *position
+	for {
+		match, found := buf.findText(text, from)
+		if !found || (first != nil && match == *first) {
+			e.StatusMsg = "No more matches"
+			return
+		}
+		if first == nil {
+			first = &match
+		}
+		matchEnd := buf.advance(match, text)
+		c := Cursor{X: matchEnd.Col, Y: matchEnd.Line, Selection: Selection{
+			StartLine: match.Line, StartCol: match.Col, EndLine: matchEnd.Line, EndCol: matchEnd.Col, Active: true,
+		}}
+		taken := c.overlaps(buf.mainCursor())
+		for _, other := range buf.Cursors {
+			taken = taken || c.overlaps(other)
+		}
+		if !taken {
+			buf.addCursor(c)
+			e.ScrollToCursor(e.CurrentPane())
+			return
+		}
+		from = matchEnd
+	}
+}
+
+// findText finds text at or after from, wrapping around the end of the
+// buffer.
+func (b *Buffer) findText(text string, from position) (position, bool) {
+	first, _, multiline := strings.Cut(text, "\n")
+	lines := b.LineCount()
+	for n := 0; n <= lines; n++ {
+		line := (from.Line + n) % lines
+		runes := []rune(b.Line(line))
+		startCol := 0
+		if n == 0 {
+			startCol = min(from.Col, len(runes))
+		}
+		if multiline {
+			// Only the end of a line can be followed by the rest
+			col := len(runes) - len([]rune(first))
+			p := position{line, col}
+			if col < startCol || !strings.HasSuffix(string(runes), first) {
+				continue
+			}
+			if n == lines && col >= from.Col {
+				// Back where we started
+				return position{}, false
+			}
+			if end := b.advance(p, text); end.Line < lines && b.textBetween(p, end) == text {
+				return p, true
+			}
+			continue
+		}
+		hay := string(runes[startCol:])
+		i := strings.Index(hay, first)
+		if i < 0 {
+			continue
+		}
+		col := startCol + len([]rune(hay[:i]))
+		if n == lines && col >= from.Col {
+			// Back where we started
+			return position{}, false
+		}
+		return position{line, col}, true
+	}
+	return position{}, false
+}
+
+// advance returns where text ends if it starts at p.
+func (b *Buffer) advance(p position, text string) position {
+	lines := strings.Split(text, "\n")
+	if len(lines) == 1 {
+		return position{p.Line, p.Col + len([]rune(text))}
+	}
+	return position{p.Line + len(lines) - 1, len([]rune(lines[len(lines)-1]))}
+}
+
+func (e *Editor) addCursorVertically(dir int) {
+	buf := e.CurrentBuffer()
+	all, _ := buf.cursorsInOrder()
+	from := all[0]
+	if dir > 0 {
+		from = all[len(all)-1]
+	}
+	line := from.Y + dir
+	if line < 0 || line >= buf.LineCount() {
+		return
+	}
+	// Keep to the same screen column across tabs
+	visual := e.charToVisualCol(buf, from.Y, from.X)
+	col := 0
+	for col < buf.lineLen(line) && e.charToVisualCol(buf, line, col+1) <= visual {
+		col++
+	}
+	buf.addCursor(Cursor{X: col, Y: line})
+	e.ScrollToCursor(e.CurrentPane())
+}
+
+func (e *Editor) AddCursorAbove() {
+	e.addCursorVertically(-1)
+}
+
+func (e *Editor) AddCursorBelow() {
+	e.addCursorVertically(1)
+}
+
+// SplitSelectionIntoLines puts a cursor at the end of the selected part of
+// each line of the selection.
+func (e *Editor) SplitSelectionIntoLines() {
+	buf := e.CurrentBuffer()
+	start, end, ok := buf.region()
+	if !ok || start.Line == end.Line {
+		return
+	}
+	buf.Selection.Active = false
+	for line := start.Line; line <= end.Line; line++ {
+		col := buf.lineLen(line)
+		if line == end.Line {
+			col = end.Col
+		}
+		if line == end.Line && col == 0 && line > start.Line {
+			// The selection only reaches the start of this line
+			continue
+		}
+		if line == start.Line {
+			buf.setMainCursor(Cursor{X: col, Y: line})
+			continue
+		}
+		buf.addCursor(Cursor{X: col, Y: line})
+	}
+	e.ScrollToCursor(e.CurrentPane())
+}
+
+// selectedTexts returns the text selected by every cursor, top to bottom.
+func (e *Editor) selectedTexts() []string {
+	var texts []string
+	all, _ := e.CurrentBuffer().cursorsInOrder()
+	for _, c := range all {
+		if c.Selection.Active {
+			texts = append(texts, e.CurrentBuffer().textBetween(c.span()))
+		}
+	}
+	return texts
+}
+
+// pasteAtCursors pastes text at every cursor, one line each if there are as
+// many lines as cursors.
+func (e *Editor) pasteAtCursors(text string) {
+	buf := e.CurrentBuffer()
+	pieces := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if len(pieces) != len(buf.Cursors)+1 {
+		pieces = nil
+	}
+	i := 0
+	e.forEachCursor(func() {
+		if buf.Selection.Active {
+			start, end, _ := buf.region()
+			buf.deleteBetween(start, end)
+			buf.Selection.Active = false
+		}
+		if pieces != nil {
+			buf.insertText(pieces[i])
+		} else {
+			buf.insertText(text)
+		}
+		i++
+	})
+}
+
+// cutAtCursors removes what every cursor has selected.
+func (e *Editor) cutAtCursors() {
+	buf := e.CurrentBuffer()
+	e.forEachCursor(func() {
+		if start, end, ok := buf.region(); ok {
+			buf.deleteBetween(start, end)
+			buf.Selection.Active = false
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// cursorList describes every cursor top to bottom, as line:col or, with a
+// selection, anchor-line:col.
+func cursorList(buf *Buffer) string {
+	all, _ := buf.cursorsInOrder()
+	var list []string
+	for _, c := range all {
+		s := fmt.Sprintf("%d:%d", c.Y, c.X)
+		if c.Selection.Active {
+			anchor := position{c.Selection.StartLine, c.Selection.StartCol}
+			if anchor == c.pos() {
+				anchor = position{c.Selection.EndLine, c.Selection.EndCol}
+			}
+			s = fmt.Sprintf("%d:%d-%s", anchor.Line, anchor.Col, s)
+		}
+		list = append(list, s)
+	}
+	return strings.Join(list, " ")
+}
+
+func TestFindText(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		lines []string
+		from  position
+		want  position
+		found bool
+	}{
+		{"same line", "foo", []string{"foo bar foo", "x foo"}, position{0, 3}, position{0, 8}, true},
+		{"next line", "foo", []string{"foo bar foo", "x foo"}, position{0, 9}, position{1, 2}, true},
+		{"wraps around", "foo", []string{"foo bar foo", "x foo"}, position{1, 5}, position{0, 0}, true},
+		{"only itself", "foo", []string{"a foo"}, position{0, 5}, position{0, 2}, true},
+		{"none", "zz", []string{"foo", "bar"}, position{0, 0}, position{}, false},
+		{"after wide runes", "é", []string{"aé é"}, position{0, 2}, position{0, 3}, true},
+		{"multi-line", "b\nc", []string{"ab", "cd", "ab", "cd"}, position{1, 1}, position{2, 1}, true},
+		{"multi-line wraps", "b\nc", []string{"ab", "cd", "ab", "cd"}, position{3, 1}, position{0, 1}, true},
+		{"multi-line needs line end", "ab\ncd", []string{"xab", "cdy", "abx", "cd"}, position{1, 0}, position{0, 1}, true},
+		{"leading newline", "\nc", []string{"ab", "cd", "ab", "cd"}, position{1, 1}, position{2, 2}, true},
+		{"leading newline, no other", "\nc", []string{"ab", "cd", "ab", "xd"}, position{1, 1}, position{0, 2}, true},
+		{"runs past the last line", "\nfoo", []string{"a", "x", "b", "foo"}, position{3, 3}, position{2, 1}, true},
+		{"trailing newline at the end", "b\n", []string{"a", "b"}, position{0, 0}, position{}, false},
+	}
+	for _, tt := range tests {
+		b := NewBuffer()
+		b.Lines = tt.lines
+		got, found := b.findText(tt.text, tt.from)
+		if found != tt.found || found && got != tt.want {
+			t.Errorf("%s: %v %v, want %v %v", tt.name, got, found, tt.want, tt.found)
+		}
+	}
+}
+
+func TestAddCursorAtNextMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		lines   []string
+		sel     Selection // none selects the word at the cursor
+		cursor  position
+		presses int
+		want    string
+	}{
+		{"word at cursor", []string{"foo bar foo"}, Selection{}, position{0, 1}, 1, "0:0-0:3"},
+		{"next match", []string{"foo bar foo"}, Selection{}, position{0, 1}, 2, "0:0-0:3 0:8-0:11"},
+		{"wraps around", []string{"x foo", "foo", "y foo"}, Selection{}, position{1, 1}, 4, "0:2-0:5 1:0-1:3 2:2-2:5"},
+		{"no more matches", []string{"foo foo"}, Selection{}, position{0, 0}, 5, "0:0-0:3 0:4-0:7"},
+		{"overlapping matches", []string{"aaa"}, Selection{StartLine: 0, StartCol: 0, EndLine: 0, EndCol: 2, Active: true},
+			position{0, 2}, 3, "0:0-0:2"},
+		{"multi-line", []string{"ab", "cd", "ab", "cd"}, Selection{StartLine: 0, StartCol: 1, EndLine: 1, EndCol: 1, Active: true},
+			position{1, 1}, 1, "0:1-1:1 2:1-3:1"},
+		{"multi-line at the end", []string{"a", "x", "b", "foo"}, Selection{StartLine: 2, StartCol: 1, EndLine: 3, EndCol: 3, Active: true},
+			position{3, 3}, 1, "2:1-3:3"},
+		{"leading newline", []string{"ab", "cd", "ab", "cd"}, Selection{StartLine: 0, StartCol: 2, EndLine: 1, EndCol: 1, Active: true},
+			position{1, 1}, 1, "0:2-1:1 2:2-3:1"},
+		{"leading newline, no other", []string{"ab", "cd", "ab", "xd"}, Selection{StartLine: 0, StartCol: 2, EndLine: 1, EndCol: 1, Active: true},
+			position{1, 1}, 1, "0:2-1:1"},
+	}
+	for _, tt := range tests {
+		e := testEditor(t, strings.Join(tt.lines, "\n"))
+		buf := e.CurrentBuffer()
+		buf.CursorY, buf.CursorX = tt.cursor.Line, tt.cursor.Col
+		buf.Selection = tt.sel
+		for range tt.presses {
+			typeKeys(e, "\x04")
+		}
+		if got := cursorList(buf); got != tt.want {
+			t.Errorf("%s: cursors %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMergeCursors(t *testing.T) {
+	sel := func(l1, c1, l2, c2 int) Selection {
+		return Selection{StartLine: l1, StartCol: c1, EndLine: l2, EndCol: c2, Active: true}
+	}
+	tests := []struct {
+		name  string
+		main  Cursor
+		extra []Cursor
+		want  string
+	}{
+		{"apart", Cursor{X: 1}, []Cursor{{X: 3}, {Y: 1}}, "0:1 0:3 1:0"},
+		{"same place", Cursor{X: 1}, []Cursor{{X: 1}, {X: 2}, {X: 2}}, "0:1 0:2"},
+		{"overlapping selections", Cursor{X: 3, Selection: sel(0, 0, 0, 3)}, []Cursor{{X: 5, Selection: sel(0, 2, 0, 5)}},
+			"0:0-0:5"},
+		{"touching selections", Cursor{X: 3, Selection: sel(0, 0, 0, 3)}, []Cursor{{X: 6, Selection: sel(0, 3, 0, 6)}},
+			"0:0-0:3 0:3-0:6"},
+		{"cursor at the start stays there", Cursor{X: 1, Selection: sel(0, 4, 0, 1)}, []Cursor{{X: 2}, {X: 6, Selection: sel(0, 3, 0, 6)}},
+			"0:6-0:1"},
+		{"extras merge with each other", Cursor{Y: 2}, []Cursor{{X: 2, Selection: sel(0, 0, 0, 2)}, {X: 1}},
+			"0:0-0:2 2:0"},
+	}
+	for _, tt := range tests {
+		b := NewBuffer()
+		b.Lines = []string{"abcdefgh", "abcdefgh", "abcdefgh"}
+		b.setMainCursor(tt.main)
+		b.Cursors = tt.extra
+		b.mergeCursors()
+		if got := cursorList(b); got != tt.want {
+			t.Errorf("%s: cursors %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPasteAtCursors(t *testing.T) {
+	tests := []struct {
+		name, clip, want string
+	}{
+		{"a line each", "1\n2\n3\n", "a1\nb2\nc3"},
+		{"a line each, no newline", "1\n2\n3", "a1\nb2\nc3"},
+		{"whole text everywhere", "xy", "axy\nbxy\ncxy"},
+		{"lines do not match the cursors", "1\n2", "a1\n2\nb1\n2\nc1\n2"},
+		{"wide runes", "é\nñ\nü", "aé\nbñ\ncü"},
+	}
+	for _, tt := range tests {
+		e := testEditor(t, "a\nb\nc")
+		e.Config.Clipboard = "internal"
+		buf := e.CurrentBuffer()
+		buf.CursorX = 1
+		buf.Cursors = []Cursor{{X: 1, Y: 1}, {X: 1, Y: 2}}
+		e.writeClipboard(tt.clip)
+		typeKeys(e, "\x16")
+		if got := strings.Join(buf.Lines, "\n"); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// editorConfigSection is one [glob] section of an .editorconfig file.
+type editorConfigSection struct {
+	glob  *editorConfigGlob
+	props map[string]string
+}
+
+// editorConfigGlob is a section name turned into a regexp. Numeric ranges
+// like {1..3} match any number and are checked against ranges afterwards.
+type editorConfigGlob struct {
+	re     *regexp.Regexp
+	ranges [][2]int
+}
+
+// editorConfig returns the .editorconfig properties for filename, reading
+// the files from its directory up to the one marked root = true. Closer
+// files win, and later sections within a file.
+func editorConfig(filename string) map[string]string {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for dir := filepath.Dir(path); ; {
+		config := filepath.Join(dir, ".editorconfig")
+		if root, ok := readEditorConfigRoot(config); ok {
+			files = append(files, config)
+			if root {
+				break
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	props := map[string]string{}
+	for i := len(files) - 1; i >= 0; i-- {
+		sections, _ := readEditorConfig(files[i])
+		rel, err := filepath.Rel(filepath.Dir(files[i]), path)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, s := range sections {
+			if s.glob.match(rel) {
+				for k, v := range s.props {
+					props[k] = v
+				}
+			}
+		}
+	}
+	return props
+}
+
+// readEditorConfigRoot reports whether path exists, and whether it says
+// root = true before its first section.
+func readEditorConfigRoot(path string) (root, ok bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			break
+		}
+		if key, value, ok := strings.Cut(line, "="); ok && strings.EqualFold(strings.TrimSpace(key), "root") {
+			root = strings.EqualFold(strings.TrimSpace(value), "true")
+		}
+	}
+	return root, true
+}
+
+func readEditorConfig(path string) ([]editorConfigSection, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var sections []editorConfigSection
+	var current *editorConfigSection
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "", line[0] == '#', line[0] == ';':
+		case line[0] == '[':
+			end := strings.LastIndex(line, "]")
+			if end < 0 {
+				current = nil
+				continue
+			}
+			current = nil
+			if glob, err := compileEditorConfigGlob(line[1:end]); err == nil {
+				sections = append(sections, editorConfigSection{glob: glob, props: map[string]string{}})
+				current = &sections[len(sections)-1]
+			}
+		case current != nil:
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			key = strings.ToLower(strings.TrimSpace(key))
+			current.props[key] = strings.ToLower(strings.TrimSpace(value))
+		}
+	}
+	return sections, scanner.Err()
+}
+
+// compileEditorConfigGlob turns a section name into a glob: * and ? stop at
+// slashes, ** does not, [abc] and [!abc] are sets, {a,b} alternatives and
+// {1..3} numbers. A name without a slash matches in any directory.
+func compileEditorConfigGlob(pattern string) (*editorConfigGlob, error) {
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	} else {
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+	g := &editorConfigGlob{}
+	var re strings.Builder
+	re.WriteString("^")
+	runes := []rune(pattern)
+	braces := 0
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '\\':
+			if i+1 < len(runes) {
+				i++
+				re.WriteString(regexp.QuoteMeta(string(runes[i])))
+			}
+		case '*':
+			switch {
+			case i+2 < len(runes) && runes[i+1] == '*' && runes[i+2] == '/':
+				re.WriteString("(?:.*/)?")
+				i += 2
+			case i+1 < len(runes) && runes[i+1] == '*':
+				re.WriteString(".*")
+				i++
+			default:
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '[':
+			end := strings.IndexRune(string(runes[i+1:]), ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			set := []rune(string(runes[i+1:])[:end])
+			i += len(set) + 1
+			re.WriteString("[")
+			if len(set) > 0 && set[0] == '!' {
+				re.WriteString("^")
+				set = set[1:]
+			}
+			re.WriteString(strings.ReplaceAll(string(set), `\`, `\\`))
+			re.WriteString("]")
+		case '{':
+			end := strings.IndexRune(string(runes[i+1:]), '}')
+			if end >= 0 {
+				inner := string(runes[i+1:])[:end]
+				if lo, hi, ok := strings.Cut(inner, ".."); ok {
+					a, errA := strconv.Atoi(lo)
+					b, errB := strconv.Atoi(hi)
+					if errA == nil && errB == nil {
+						g.ranges = append(g.ranges, [2]int{min(a, b), max(a, b)})
+						re.WriteString(`([+-]?\d+)`)
+						i += len([]rune(inner)) + 1
+						continue
+					}
+				}
+				if !strings.Contains(inner, ",") && !strings.ContainsAny(inner, "{}") {
+					// {single} is literal
+					re.WriteString(regexp.QuoteMeta("{" + inner + "}"))
+					i += len([]rune(inner)) + 1
+					continue
+				}
+			}
+			braces++
+			re.WriteString("(?:")
+		case '}':
+			if braces == 0 {
+				re.WriteString(`\}`)
+				continue
+			}
+			braces--
+			re.WriteString(")")
+		case ',':
+			if braces == 0 {
+				re.WriteString(",")
+				continue
+			}
+			re.WriteString("|")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	for ; braces > 0; braces-- {
+		re.WriteString(")")
+	}
+	re.WriteString("$")
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return nil, err
+	}
+	g.re = compiled
+	return g, nil
+}
+
+func (g *editorConfigGlob) match(path string) bool {
+	m := g.re.FindStringSubmatch(path)
+	if m == nil {
+		return false
+	}
+	for i, r := range g.ranges {
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil || n < r[0] || n > r[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// applyEditorConfig sets b's indentation and file format from props.
+func (b *Buffer) applyEditorConfig(props map[string]string) {
+	flag := func(v bool) *bool { return &v }
+	number := func(key string) (int, bool) {
+		n, err := strconv.Atoi(props[key])
+		return n, err == nil && n > 0 && n <= 16
+	}
+	switch props["indent_style"] {
+	case "tab":
+		b.ExpandTab = flag(false)
+	case "space":
+		b.ExpandTab = flag(true)
+	}
+	if n, ok := number("tab_width"); ok {
+		b.TabWidth = n
+	}
+	if props["indent_size"] == "tab" {
+		b.ShiftWidth = 0
+	} else if n, ok := number("indent_size"); ok {
+		b.ShiftWidth = n
+		if _, ok := number("tab_width"); !ok {
+			b.TabWidth = n
+		}
+	}
+	switch props["end_of_line"] {
+	case "lf":
+		b.Format.lineEnding = "\n"
+	case "crlf":
+		b.Format.lineEnding = "\r\n"
+	case "cr":
+		b.Format.lineEnding = "\r"
+	}
+	switch props["trim_trailing_whitespace"] {
+	case "true":
+		b.Format.trimTrailing = true
+	case "false":
+		b.Format.trimTrailing = false
+	}
+	switch props["insert_final_newline"] {
+	case "true":
+		b.Format.finalNewline = flag(true)
+	case "false":
+		b.Format.finalNewline = flag(false)
+	}
+}
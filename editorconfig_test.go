@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEditorConfigGlob(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"*", "a.go", true},
+		{"*", "src/a.go", true},
+		{"*.go", "a.go", true},
+		{"*.go", "src/deep/a.go", true},
+		{"*.go", "a.goo", false},
+		{"src/*.go", "src/a.go", true},
+		{"src/*.go", "src/x/a.go", false},
+		{"src/*.go", "lib/src/a.go", false},
+		{"/src/*.go", "src/a.go", true},
+		{"src/**/*.go", "src/a.go", true},
+		{"src/**/*.go", "src/x/y/a.go", true},
+		{"src/**.go", "src/x/a.go", true},
+		{"a?c", "abc", true},
+		{"a?c", "a/c", false},
+		{"[abc].c", "b.c", true},
+		{"[abc].c", "d.c", false},
+		{"[!abc].c", "d.c", true},
+		{"[!abc].c", "a.c", false},
+		{"{*.js,*.ts}", "a.ts", true},
+		{"{*.js,*.ts}", "a.py", false},
+		{"*.{js,ts}", "lib/a.js", true},
+		{"*.{js,ts}", "a.jsx", false},
+		{"{a,{b,c}}.txt", "c.txt", true},
+		{"file{1..3}.txt", "file2.txt", true},
+		{"file{1..3}.txt", "file4.txt", false},
+		{"file{1..3}.txt", "file10.txt", false},
+		{"file{3..1}.txt", "file1.txt", true},
+		{"file{-2..2}.txt", "file-1.txt", true},
+		{"{single}.txt", "{single}.txt", true},
+		{"{single}.txt", "single.txt", false},
+		{`\*.txt`, "*.txt", true},
+		{`\*.txt`, "a.txt", false},
+		{"Makefile", "sub/Makefile", true},
+	}
+	for _, tt := range tests {
+		g, err := compileEditorConfigGlob(tt.pattern)
+		if err != nil {
+			t.Errorf("%q: %v", tt.pattern, err)
+			continue
+		}
+		if got := g.match(tt.path); got != tt.want {
+			t.Errorf("%q matching %q: %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func writeEditorConfig(t *testing.T, dir, content string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".editorconfig"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEditorConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	// Above the root, so never read
+	writeEditorConfig(t, dir, "[*]\ncharset = latin1\n")
+	project := filepath.Join(dir, "project")
+	writeEditorConfig(t, project, `root = true
+
+[*]
+indent_style = space
+indent_size = 4
+end_of_line = lf
+
+# Later sections win over earlier ones
+[*.go]
+indent_style = tab
+END_OF_LINE = CRLF
+`)
+	// Closer files win over the root one
+	writeEditorConfig(t, filepath.Join(project, "sub"), "[*.go]\nindent_size = 8\n")
+
+	tests := []struct {
+		file string
+		want map[string]string
+	}{
+		{"project/a.txt", map[string]string{"indent_style": "space", "indent_size": "4", "end_of_line": "lf"}},
+		{"project/a.go", map[string]string{"indent_style": "tab", "indent_size": "4", "end_of_line": "crlf"}},
+		{"project/sub/a.go", map[string]string{"indent_style": "tab", "indent_size": "8", "end_of_line": "crlf"}},
+		{"project/sub/a.txt", map[string]string{"indent_style": "space", "indent_size": "4", "end_of_line": "lf"}},
+		{"other/a.go", map[string]string{"charset": "latin1"}},
+	}
+	for _, tt := range tests {
+		got := editorConfig(filepath.Join(dir, tt.file))
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: %v, want %v", tt.file, got, tt.want)
+			continue
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("%s: %s = %q, want %q", tt.file, k, got[k], v)
+			}
+		}
+	}
+}
+
+func TestApplyEditorConfig(t *testing.T) {
+	tests := []struct {
+		props           map[string]string
+		tabWidth, shift int
+		expandTab       string
+		lineEnding      string
+		trim            bool
+		finalNewline    string
+	}{
+		{map[string]string{}, 0, 0, "unset", "", false, "unset"},
+		{map[string]string{"indent_style": "space", "indent_size": "2"}, 2, 2, "true", "", false, "unset"},
+		{map[string]string{"indent_style": "tab", "indent_size": "4", "tab_width": "8"}, 8, 4, "false", "", false, "unset"},
+		{map[string]string{"indent_size": "tab", "tab_width": "3"}, 3, 0, "unset", "", false, "unset"},
+		{map[string]string{"indent_size": "99"}, 0, 0, "unset", "", false, "unset"},
+		{map[string]string{"end_of_line": "crlf", "trim_trailing_whitespace": "true", "insert_final_newline": "false"},
+			0, 0, "unset", "\r\n", true, "false"},
+		{map[string]string{"end_of_line": "cr", "insert_final_newline": "true"}, 0, 0, "unset", "\r", false, "true"},
+	}
+	for _, tt := range tests {
+		b := NewBuffer()
+		b.applyEditorConfig(tt.props)
+		expandTab := flagName(b.ExpandTab)
+		if b.TabWidth != tt.tabWidth || b.ShiftWidth != tt.shift || expandTab != tt.expandTab {
+			t.Errorf("%v: tabwidth %d shiftwidth %d expandtab %s, want %d %d %s",
+				tt.props, b.TabWidth, b.ShiftWidth, expandTab, tt.tabWidth, tt.shift, tt.expandTab)
+		}
+		f := b.Format
+		if f.lineEnding != tt.lineEnding || f.trimTrailing != tt.trim || flagName(f.finalNewline) != tt.finalNewline {
+			t.Errorf("%v: line ending %q trim %v final newline %s, want %q %v %s", tt.props,
+				f.lineEnding, f.trimTrailing, flagName(f.finalNewline), tt.lineEnding, tt.trim, tt.finalNewline)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const killRingSize = 32
+
+// killRing holds killed text, newest last. yank puts back the newest entry
+// and yank.pop swaps what it put in for older ones.
+type killRing struct {
+	entries    []string
+	yank       int // entry the last yank put in
+	yankStart  position
+	yankEnd    position
+	yankBuffer *Buffer
+}
+
+func (r *killRing) push(text string) {
+	r.entries = append(r.entries, text)
+	if len(r.entries) > killRingSize {
+		r.entries = r.entries[1:]
+	}
+}
+
+func emacsKeymap() map[string]string {
+	return map[string]string{
+		"ctrl+a":        "move.linestart",
+		"ctrl+e":        "move.lineend",
+		"ctrl+f":        "move.right",
+		"ctrl+b":        "move.left",
+		"ctrl+n":        "move.down",
+		"ctrl+p":        "move.up",
+		"alt+f":         "move.wordright",
+		"alt+b":         "move.wordleft",
+		"ctrl+d":        "delete.forward",
+		"ctrl+k":        "kill.line",
+		"ctrl+w":        "kill.region",
+		"alt+w":         "kill.copy",
+		"ctrl+y":        "yank",
+		"alt+y":         "yank.pop",
+		"ctrl+space":    "mark.set",
+		"ctrl+g":        "cancel",
+		"ctrl+s":        "search",
+		"alt+x":         "command",
+		"ctrl+x ctrl+s": "save",
+		"ctrl+x ctrl+f": "file.open",
+		"ctrl+x ctrl+c": "quit",
+		"ctrl+x 2":      "pane.hsplit",
+		"ctrl+x 3":      "pane.vsplit",
+		"ctrl+x o":      "pane.next",
+		"ctrl+x 0":      "pane.close",
+		"ctrl+x space":  "selection.block",
+		"ctrl+x u":      "undo",
+		"ctrl+x r s":    "register.copy",
+		"ctrl+x r i":    "register.paste",
+		"ctrl+x r l":    "clipboard.history",
+		"ctrl+x U":      "redo",
+		"ctrl+x (":      "macro.record",
+		"ctrl+x )":      "macro.record",
+		"ctrl+x e":      "macro.replay",
+		"ctrl+]":        "bracket.match",
+		"ctrl+v":        "move.pagedown",
+		"alt+v":         "move.pageup",
+		"alt+<":         "move.top",
+		"alt+>":         "move.bottom",
+		"home":          "move.home",
+		"end":           "move.end",
+		"pgup":          "move.pageup",
+		"pgdn":          "move.pagedown",
+		"ctrl+home":     "move.top",
+		"ctrl+end":      "move.bottom",
+		"esc":           "cancel",
+		"up":            "move.up",
+		"down":          "move.down",
+		"left":          "move.left",
+		"right":         "move.right",
+		"enter":         "newline",
+		"backspace":     "delete.back",
+		"delete":        "delete.forward",
+		"tab":           "insert.tab",
+		"backtab":       "indent.less",
+	}
+}
+
+// SetMark starts a selection at the cursor that grows as the cursor moves,
+// until it is used or cancelled.
+func (e *Editor) SetMark() {
+	buf := e.CurrentBuffer()
+	buf.Selection = Selection{
+		StartLine: buf.CursorY, StartCol: buf.CursorX,
+		EndLine: buf.CursorY, EndCol: buf.CursorX,
+		Active: true, Mark: true,
+	}
+	e.StatusMsg = "Mark set"
+}
+
+// region returns the selection in order, or false if there is none.
+func (b *Buffer) region() (start, end position, ok bool) {
+	if !b.Selection.Active {
+		return start, end, false
+	}
+	start = position{b.Selection.StartLine, b.Selection.StartCol}
+	end = position{b.Selection.EndLine, b.Selection.EndCol}
+	if end.before(start) {
+		start, end = end, start
+	}
+	return start, end, true
+}
+
+// kill adds text to the kill ring and the clipboard. Kills straight after
+// another kill add to its entry instead, so C-k C-k takes a line and its
+// newline back together.
+func (e *Editor) kill(text string, prepend bool) {
+	ring := &e.killRing
+	if strings.HasPrefix(e.prevAction, "kill.") && len(ring.entries) > 0 {
+		// The longer kill replaces this one in the clipboard ring too
+		if len(e.clipRing) > 0 && e.clipRing[len(e.clipRing)-1] == ring.entries[len(ring.entries)-1] {
+			e.clipRing = e.clipRing[:len(e.clipRing)-1]
+		}
+		last := &ring.entries[len(ring.entries)-1]
+		if prepend {
+			*last = text + *last
+		} else {
+			*last += text
+		}
+	} else {
+		ring.push(text)
+	}
+	e.writeClipboard(ring.entries[len(ring.entries)-1])
+}
+
+// KillLine kills from the cursor to the end of the line, or the line break
+// if the cursor is already there.
+func (e *Editor) KillLine() {
+	buf := e.CurrentBuffer()
+	if e.readOnly(buf) {
+		return
+	}
+	buf.Selection.Active = false
+	start := position{buf.CursorY, min(buf.CursorX, buf.lineLen(buf.CursorY))}
+	end := position{start.Line, buf.lineLen(start.Line)}
+	if start == end {
+		if start.Line >= buf.LineCount()-1 {
+			e.StatusMsg = "End of buffer"
+			return
+		}
+		end = position{start.Line + 1, 0}
+	}
+	e.kill(buf.textBetween(start, end), false)
+	buf.deleteBetween(start, end)
+	e.ScrollToCursor(e.CurrentPane())
+}
+
+func (e *Editor) KillRegion() {
+	buf := e.CurrentBuffer()
+	if e.readOnly(buf) {
+		return
+	}
+	start, end, ok := buf.region()
+	if !ok {
+		e.StatusMsg = "The mark is not set now, so there is no region"
+		return
+	}
+	e.kill(buf.textBetween(start, end), buf.CursorY == start.Line && buf.CursorX == start.Col && start != end)
+	buf.deleteBetween(start, end)
+	buf.Selection.Active = false
+	e.ScrollToCursor(e.CurrentPane())
+}
+
+// KillCopy puts the region in the kill ring without removing it.
+func (e *Editor) KillCopy() {
+	buf := e.CurrentBuffer()
+	start, end, ok := buf.region()
+	if !ok {
+		e.StatusMsg = "The mark is not set now, so there is no region"
+		return
+	}
+	e.kill(buf.textBetween(start, end), false)
+	buf.Selection.Active = false
+}
+
+func (e *Editor) Yank() {
+	buf := e.CurrentBuffer()
+	if e.readOnly(buf) {
+		return
+	}
+	ring := &e.killRing
+	// Something copied outside accela is newer than any kill
+	text, err := e.readClipboard()
+	if err != nil {
+		e.StatusMsg = fmt.Sprintf("Error: %v", err)
+	} else if text != "" && (len(ring.entries) == 0 || text != ring.entries[len(ring.entries)-1]) {
+		ring.push(text)
+	}
+	if len(ring.entries) == 0 {
+		e.StatusMsg = "Kill ring is empty"
+		return
+	}
+	if buf.Selection.Active {
+		start, end, _ := buf.region()
+		buf.deleteBetween(start, end)
+		buf.Selection.Active = false
+	}
+	ring.yank = len(ring.entries) - 1
+	e.yankEntry(buf)
+}
+
+// YankPop replaces the text the last yank put in with the kill before it.
+func (e *Editor) YankPop() {
+	buf := e.CurrentBuffer()
+	ring := &e.killRing
+	if (e.prevAction != "yank" && e.prevAction != "yank.pop") || ring.yankBuffer != buf {
+		e.StatusMsg = "Previous command was not a yank"
+		return
+	}
+	buf.deleteBetween(ring.yankStart, ring.yankEnd)
+	ring.yank = (ring.yank - 1 + len(ring.entries)) % len(ring.entries)
+	e.yankEntry(buf)
+}
+
+func (e *Editor) yankEntry(buf *Buffer) {
+	ring := &e.killRing
+	ring.yankBuffer = buf
+	ring.yankStart = position{buf.CursorY, min(buf.CursorX, buf.lineLen(buf.CursorY))}
+	buf.CursorX = ring.yankStart.Col
+	buf.insertText(ring.entries[ring.yank])
+	ring.yankEnd = position{buf.CursorY, buf.CursorX}
+	e.ScrollToCursor(e.CurrentPane())
+}
+
+// runCommand runs cmd as if it was typed in the command bar.
+func (e *Editor) runCommand(cmd string) {
+	e.Command = cmd
+	e.ExecuteCommand()
+	e.Command = ""
+}
+
+// PromptOpenFile prompts for a file to edit in the command bar.
+func (e *Editor) PromptOpenFile() {
+	e.OpenCommandBar()
+	e.Command = "edit "
+}
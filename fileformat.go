@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// fileFormat is how a buffer's text is written back to its file.
+type fileFormat struct {
+	lineEnding   string // "\n", "\r\n" or "\r"
+	charset      string // "utf-8", "utf-8-bom", "latin1", "utf-16be" or "utf-16le"
+	trimTrailing bool   // strip whitespace at the end of lines on save
+	finalNewline *bool  // make sure the file ends in a line break, or does not; nil leaves it
+}
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16BE = []byte{0xfe, 0xff}
+	bomUTF16LE = []byte{0xff, 0xfe}
+)
+
+// decodeText turns data into text, going by its byte order mark or else by
+// charset. ok is false for data that is not text in that charset.
+func decodeText(data []byte, charset string) (text, detected string, ok bool) {
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		data, charset = data[len(bomUTF8):], "utf-8-bom"
+	case bytes.HasPrefix(data, bomUTF16BE):
+		data, charset = data[len(bomUTF16BE):], "utf-16be"
+	case bytes.HasPrefix(data, bomUTF16LE):
+		data, charset = data[len(bomUTF16LE):], "utf-16le"
+	}
+	switch charset {
+	case "latin1":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes), charset, true
+	case "utf-16be", "utf-16le":
+		if len(data)%2 != 0 {
+			return "", charset, false
+		}
+		var order binary.ByteOrder = binary.BigEndian
+		if charset == "utf-16le" {
+			order = binary.LittleEndian
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			units[i] = order.Uint16(data[2*i:])
+		}
+		return string(utf16.Decode(units)), charset, true
+	case "utf-8-bom":
+	default:
+		charset = "utf-8"
+	}
+	return string(data), charset, !isBinary(data)
+}
+
+// encodeText turns text back into the bytes of charset.
+func encodeText(text, charset string) ([]byte, error) {
+	switch charset {
+	case "utf-8-bom":
+		return append(append([]byte(nil), bomUTF8...), text...), nil
+	case "latin1":
+		data := make([]byte, 0, len(text))
+		for _, r := range text {
+			if r > 0xff {
+				return nil, fmt.Errorf("%q cannot be written as latin1", r)
+			}
+			data = append(data, byte(r))
+		}
+		return data, nil
+	case "utf-16be", "utf-16le":
+		var order binary.AppendByteOrder = binary.BigEndian
+		bom := bomUTF16BE
+		if charset == "utf-16le" {
+			order, bom = binary.LittleEndian, bomUTF16LE
+		}
+		data := append([]byte(nil), bom...)
+		for _, unit := range utf16.Encode([]rune(text)) {
+			data = order.AppendUint16(data, unit)
+		}
+		return data, nil
+	}
+	return []byte(text), nil
+}
+
+// detectLineEnding returns the line break text uses, and text with all of
+// them turned into "\n".
+func detectLineEnding(text string) (string, string) {
+	switch {
+	case strings.Contains(text, "\r\n"):
+		return "\r\n", strings.ReplaceAll(text, "\r\n", "\n")
+	case strings.Contains(text, "\r") && !strings.Contains(text, "\n"):
+		return "\r", strings.ReplaceAll(text, "\r", "\n")
+	}
+	return "\n", text
+}
+
+// trimTrailingSpace strips whitespace from the ends of b's lines, keeping
+// the cursor on them.
+func (b *Buffer) trimTrailingSpace() {
+	first := -1
+	for i, line := range b.Lines {
+		if trimmed := strings.TrimRight(line, " \t"); trimmed != line {
+			b.Lines[i] = trimmed
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+	if first < 0 {
+		return
+	}
+	b.CursorX = min(b.CursorX, b.lineLen(b.CursorY))
+	for i := range b.Cursors {
+		b.Cursors[i].X = min(b.Cursors[i].X, b.lineLen(b.Cursors[i].Y))
+	}
+	b.MarkDirtyLines(first, len(b.Lines)-1)
+}
+
+// encode is the file content of lines in format f.
+func (f fileFormat) encode(lines []string) ([]byte, error) {
+	content := strings.Join(lines, "\n")
+	if f.finalNewline != nil && *f.finalNewline && content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	} else if f.finalNewline != nil && !*f.finalNewline {
+		content = strings.TrimSuffix(content, "\n")
+	}
+	if f.lineEnding != "" && f.lineEnding != "\n" {
+		content = strings.ReplaceAll(content, "\n", f.lineEnding)
+	}
+	return encodeText(content, f.charset)
+}
+
+// name describes f for setlocal, e.g. "crlf utf-8".
+func (f fileFormat) name() string {
+	eol := map[string]string{"\r\n": "crlf", "\r": "cr"}[f.lineEnding]
+	if eol == "" {
+		eol = "lf"
+	}
+	charset := f.charset
+	if charset == "" {
+		charset = "utf-8"
+	}
+	return eol + " " + charset
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		charset  string
+		text     string
+		detected string
+		ok       bool
+	}{
+		{"plain", "hi\n", "", "hi\n", "utf-8", true},
+		{"utf-8 asked for", "héllo", "utf-8", "héllo", "utf-8", true},
+		{"utf-8 bom", "\xef\xbb\xbfhi", "", "hi", "utf-8-bom", true},
+		{"bom wins", "\xef\xbb\xbfhi", "latin1", "hi", "utf-8-bom", true},
+		{"utf-16le bom", "\xff\xfeh\x00i\x00", "", "hi", "utf-16le", true},
+		{"utf-16be bom", "\xfe\xff\x00h\x00i", "", "hi", "utf-16be", true},
+		{"utf-16le surrogates", "\xff\xfe\x3d\xd8\x00\xde", "", "😀", "utf-16le", true},
+		{"utf-16le without bom", "h\x00i\x00", "utf-16le", "hi", "utf-16le", true},
+		{"utf-16 odd length", "\xff\xfeh\x00i", "", "", "utf-16le", false},
+		{"latin1", "caf\xe9", "latin1", "café", "latin1", true},
+		{"invalid utf-8", "caf\xe9", "", "caf\xe9", "utf-8", false},
+		{"nul byte", "a\x00b", "", "a\x00b", "utf-8", false},
+		{"unknown charset", "abc", "ebcdic", "abc", "utf-8", true},
+		{"empty", "", "", "", "utf-8", true},
+	}
+	for _, tt := range tests {
+		text, detected, ok := decodeText([]byte(tt.data), tt.charset)
+		if ok != tt.ok || detected != tt.detected || ok && text != tt.text {
+			t.Errorf("%s: %q %q %v, want %q %q %v", tt.name, text, detected, ok, tt.text, tt.detected, tt.ok)
+		}
+	}
+}
+
+func TestEncodeText(t *testing.T) {
+	tests := []struct {
+		text, charset, want string
+	}{
+		{"hi", "", "hi"},
+		{"hi", "utf-8", "hi"},
+		{"hi", "utf-8-bom", "\xef\xbb\xbfhi"},
+		{"café", "latin1", "caf\xe9"},
+		{"hi", "utf-16le", "\xff\xfeh\x00i\x00"},
+		{"hi", "utf-16be", "\xfe\xff\x00h\x00i"},
+		{"😀", "utf-16le", "\xff\xfe\x3d\xd8\x00\xde"},
+	}
+	for _, tt := range tests {
+		data, err := encodeText(tt.text, tt.charset)
+		if err != nil || string(data) != tt.want {
+			t.Errorf("%q as %s: %q %v, want %q", tt.text, tt.charset, data, err, tt.want)
+			continue
+		}
+		// Whatever is written reads back the same
+		text, detected, ok := decodeText(data, tt.charset)
+		if !ok || text != tt.text {
+			t.Errorf("%q as %s read back as %q %s %v", tt.text, tt.charset, text, detected, ok)
+		}
+	}
+	if _, err := encodeText("5€", "latin1"); err == nil {
+		t.Errorf("€ written as latin1")
+	}
+}
+
+func TestDetectLineEnding(t *testing.T) {
+	tests := []struct {
+		text, ending, normalized string
+	}{
+		{"a\nb\n", "\n", "a\nb\n"},
+		{"a\r\nb\r\n", "\r\n", "a\nb\n"},
+		{"a\rb\r", "\r", "a\nb\n"},
+		{"a\r\nb\n", "\r\n", "a\nb\n"},
+		{"a\rb\n", "\n", "a\rb\n"},
+		{"", "\n", ""},
+	}
+	for _, tt := range tests {
+		ending, normalized := detectLineEnding(tt.text)
+		if ending != tt.ending || normalized != tt.normalized {
+			t.Errorf("%q: %q %q, want %q %q", tt.text, ending, normalized, tt.ending, tt.normalized)
+		}
+	}
+}
+
+func TestFileFormatEncode(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name   string
+		format fileFormat
+		lines  []string
+		want   string
+	}{
+		{"as is", fileFormat{}, []string{"a", "b"}, "a\nb"},
+		{"as is with newline", fileFormat{}, []string{"a", "b", ""}, "a\nb\n"},
+		{"add final newline", fileFormat{finalNewline: &yes}, []string{"a", "b"}, "a\nb\n"},
+		{"keep final newline", fileFormat{finalNewline: &yes}, []string{"a", ""}, "a\n"},
+		{"empty file stays empty", fileFormat{finalNewline: &yes}, []string{""}, ""},
+		{"drop final newline", fileFormat{finalNewline: &no}, []string{"a", ""}, "a"},
+		{"crlf", fileFormat{lineEnding: "\r\n", finalNewline: &yes}, []string{"a", "b"}, "a\r\nb\r\n"},
+		{"cr", fileFormat{lineEnding: "\r"}, []string{"a", "b", ""}, "a\rb\r"},
+		{"utf-16le crlf", fileFormat{lineEnding: "\r\n", charset: "utf-16le"}, []string{"a", ""}, "\xff\xfea\x00\r\x00\n\x00"},
+	}
+	for _, tt := range tests {
+		data, err := tt.format.encode(tt.lines)
+		if err != nil || !bytes.Equal(data, []byte(tt.want)) {
+			t.Errorf("%s: %q %v, want %q", tt.name, data, err, tt.want)
+		}
+	}
+}
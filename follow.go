@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+const followInterval = 250 * time.Millisecond
+
+type follower struct {
+	path      string
+	file      *os.File
+	offset    int64
+	stop      chan struct{}
+	wasLocked bool
+}
+
+// followEvent carries bytes read from a followed file back to the UI
+// goroutine. Reset means the file was truncated or rotated and Data is its
+// whole new content. Follower tells events of a stopped follow apart from
+// those of one started after it.
+type followEvent struct {
+	tcell.EventTime
+	Buffer   *Buffer
+	Follower *follower
+	Data     []byte
+	Reset    bool
+}
+
+func (e *Editor) StartFollow(buf *Buffer) error {
+	if buf.Filename == "" {
+		return fmt.Errorf("no filename")
+	}
+	if buf.Hex != nil {
+		return fmt.Errorf("cannot follow a binary file")
+	}
+	if buf.Large != nil {
+		return fmt.Errorf("cannot follow a large file")
+	}
+	if buf.Modified {
+		// Following reloads the file over them
+		return fmt.Errorf("unsaved changes, save first")
+	}
+	f, err := os.Open(buf.Filename)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	data = holdCR(data)
+
+	buf.replaceContent(data)
+	buf.Selection.Active = false
+	buf.CursorY = len(buf.Lines) - 1
+	buf.CursorX = 0
+
+	fl := &follower{
+		path:      buf.Filename,
+		file:      f,
+		offset:    int64(len(data)),
+		stop:      make(chan struct{}),
+		wasLocked: buf.ReadOnly,
+	}
+	buf.Follow = fl
+	buf.ReadOnly = true
+	go fl.run(e.Screen, buf)
+	return nil
+}
+
+// replaceContent swaps in a fresh copy of the file, keeping ANSI rendering on
+// if it was.
+func (b *Buffer) replaceContent(data []byte) {
+	ansi := b.ANSI != nil
+	b.ANSI = nil
+	b.setContent(string(data))
+	b.SetupHighlighting()
+	if ansi {
+		b.EnableANSI()
+	}
+}
+
+func (b *Buffer) StopFollow() {
+	if b.Follow == nil {
+		return
+	}
+	close(b.Follow.stop)
+	b.ReadOnly = b.Follow.wasLocked
+	b.Follow = nil
+}
+
+func (f *follower) run(screen tcell.Screen, buf *Buffer) {
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+	defer func() { f.file.Close() }()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+
+		reset := false
+		fi, err := os.Stat(f.path)
+		if err != nil {
+			// Rotated away and not recreated yet
+			continue
+		}
+		cur, err := f.file.Stat()
+		if err != nil || !os.SameFile(fi, cur) || fi.Size() < f.offset {
+			nf, err := os.Open(f.path)
+			if err != nil {
+				continue
+			}
+			f.file.Close()
+			f.file = nf
+			f.offset = 0
+			reset = true
+		} else if fi.Size() == f.offset {
+			continue
+		}
+
+		if _, err := f.file.Seek(f.offset, io.SeekStart); err != nil {
+			continue
+		}
+		data, err := io.ReadAll(f.file)
+		if err != nil {
+			continue
+		}
+		data = holdCR(data)
+		if len(data) == 0 && !reset {
+			continue
+		}
+		f.offset += int64(len(data))
+
+		ev := &followEvent{Buffer: buf, Follower: f, Data: data, Reset: reset}
+		ev.SetEventNow()
+		screen.PostEvent(ev)
+	}
+}
+
+// holdCR leaves a "\r" at the end of data to be read again with what comes
+// after it, so a "\r\n" split between two reads still ends a single line.
+func holdCR(data []byte) []byte {
+	return bytes.TrimSuffix(data, []byte("\r"))
+}
+
+// AppendFollowed applies a followEvent. Panes whose cursor sits on the last
+// line keep tailing the file.
+func (e *Editor) AppendFollowed(ev *followEvent) {
+	buf := ev.Buffer
+	if buf.Follow != ev.Follower {
+		return
+	}
+	atEnd := buf.CursorY >= len(buf.Lines)-1
+
+	if ev.Reset {
+		buf.replaceContent(ev.Data)
+		buf.Selection.Active = false
+		buf.CursorY = min(buf.CursorY, len(buf.Lines)-1)
+		buf.CursorX = 0
+		e.StatusMsg = fmt.Sprintf("%s: file truncated or rotated, reloaded", buf.Filename)
+	} else {
+		content := strings.ReplaceAll(string(ev.Data), "\r\n", "\n")
+		parts := strings.Split(content, "\n")
+		if buf.ANSI != nil {
+			buf.appendANSI(parts)
+		} else {
+			last := len(buf.Lines) - 1
+			buf.Lines[last] += parts[0]
+			buf.Lines = append(buf.Lines, parts[1:]...)
+			buf.MarkDirtyLines(last, len(buf.Lines)-1)
+		}
+	}
+	// What was added is in the file already
+	buf.Modified = false
+
+	if atEnd {
+		buf.CursorY = len(buf.Lines) - 1
+		buf.CursorX = 0
+	}
+	for _, pane := range e.Panes {
+		if pane.Buffer == buf {
+			e.ScrollToCursor(pane)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// hexView is the state of a binary buffer. Binary files never go through
+// Buffer.Lines, so they are written back exactly as read.
+type hexView struct {
+	Data        []byte
+	Cursor      int  // byte offset
+	Nibble      int  // 0 = high nibble, 1 = low nibble
+	ASCII       bool // editing in the ASCII column
+	Insert      bool
+	Top         int // first displayed row
+	BytesPerRow int
+	undo, redo  []hexEdit
+}
+
+// hexEdit is one change to a binary buffer: old was replaced by new at off.
+type hexEdit struct {
+	off      int
+	old, new []byte
+	cursor   int // where the cursor was before
+}
+
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data)
+}
+
+func (h *hexView) bytesPerRow() int {
+	if h.BytesPerRow <= 0 {
+		return 16
+	}
+	return h.BytesPerRow
+}
+
+func (e *Editor) DrawHexPane(pane *Pane, active bool) {
+	h := pane.Buffer.Hex
+	// Drawn straight to the screen, so the text frame no longer matches it
+	pane.frame = nil
+	gutterStyle := e.Theme.Gutter
+	bgStyle := e.Theme.Background
+	cursorStyle := bgStyle.Reverse(true)
+	shadowStyle := bgStyle.Underline(true)
+	searchStyle := e.Theme.Search
+
+	// "00000000  xx xx ... xx  xx ... xx  |................|"
+	perRow := 16
+	if pane.Width < 10+perRow*3+1+perRow+2 {
+		perRow = 8
+	}
+	h.BytesPerRow = perRow
+	pane.GutterWidth = 10
+	hexCol := func(i int) int {
+		col := pane.GutterWidth + i*3
+		if i >= perRow/2 {
+			col++
+		}
+		return col
+	}
+	asciiStart := hexCol(perRow) + 1
+
+	put := func(x, y int, ch rune, style tcell.Style) {
+		if x < pane.Width {
+			e.Screen.SetContent(pane.X+x, pane.Y+y, ch, nil, style)
+		}
+	}
+
+	for row := 0; row < pane.Height; row++ {
+		for col := 0; col < pane.Width; col++ {
+			put(col, row, ' ', bgStyle)
+		}
+		rowOffset := (h.Top + row) * perRow
+		// The row after the last byte only shows up to hold an appending cursor
+		if rowOffset > len(h.Data) || (rowOffset == len(h.Data) && h.Cursor != rowOffset) {
+			continue
+		}
+		for i, ch := range fmt.Sprintf("%08x", rowOffset) {
+			put(i, row, ch, gutterStyle)
+		}
+		put(asciiStart-1, row, '|', gutterStyle)
+		for i := 0; i < perRow; i++ {
+			off := rowOffset + i
+			if off > len(h.Data) {
+				break
+			}
+			hexStyle, asciiStyle := bgStyle, bgStyle
+			if e.isHexSearchMatch(off) {
+				hexStyle, asciiStyle = searchStyle, searchStyle
+			}
+			if off == h.Cursor {
+				if h.ASCII {
+					hexStyle, asciiStyle = shadowStyle, cursorStyle
+				} else {
+					hexStyle, asciiStyle = cursorStyle, shadowStyle
+				}
+			}
+			if off == len(h.Data) {
+				if off == h.Cursor {
+					put(hexCol(i), row, ' ', hexStyle)
+					put(asciiStart+i, row, ' ', asciiStyle)
+				}
+				break
+			}
+			b := h.Data[off]
+			digits := fmt.Sprintf("%02x", b)
+			put(hexCol(i), row, rune(digits[0]), hexStyle)
+			put(hexCol(i)+1, row, rune(digits[1]), hexStyle)
+			ch := rune(b)
+			if b < 0x20 || b >= 0x7f {
+				ch = '.'
+			}
+			put(asciiStart+i, row, ch, asciiStyle)
+			if i == perRow-1 || off == len(h.Data)-1 {
+				put(asciiStart+i+1, row, '|', gutterStyle)
+			}
+		}
+	}
+
+	if active {
+		row := h.Cursor/perRow - h.Top
+		i := h.Cursor % perRow
+		x := hexCol(i) + h.Nibble
+		if h.ASCII {
+			x = asciiStart + i
+		}
+		if row >= 0 && row < pane.Height && x < pane.Width {
+			e.Screen.ShowCursor(pane.X+x, pane.Y+row)
+		}
+	}
+}
+
+func (e *Editor) isHexSearchMatch(off int) bool {
+	// Matches are in offset order and never longer than the needle
+	matches := e.SearchMatches
+	i := sort.Search(len(matches), func(i int) bool { return matches[i].Col+matches[i].Len > off })
+	return i < len(matches) && matches[i].Col <= off
+}
+
+func (e *Editor) ScrollHexToCursor(pane *Pane) {
+	h := pane.Buffer.Hex
+	row := h.Cursor / h.bytesPerRow()
+	if row < h.Top {
+		h.Top = row
+	} else if row >= h.Top+pane.Height {
+		h.Top = row - pane.Height + 1
+	}
+}
+
+func (h *hexView) moveTo(off int) {
+	h.Cursor = max(0, min(off, len(h.Data)))
+	h.Nibble = 0
+}
+
+// replace puts data in place of the n bytes at off, as a step undo can take
+// back.
+func (h *hexView) replace(off, n int, data []byte) {
+	h.undo = append(h.undo, hexEdit{
+		off:    off,
+		old:    bytes.Clone(h.Data[off : off+n]),
+		new:    bytes.Clone(data),
+		cursor: h.Cursor,
+	})
+	if len(h.undo) > undoLimit {
+		h.undo = h.undo[1:]
+	}
+	h.redo = nil
+	h.Data = slices.Replace(h.Data, off, off+n, data...)
+}
+
+// undoHex takes back the last change to h, or with redo puts back the last
+// one taken back.
+func (e *Editor) undoHex(h *hexView, redo bool) {
+	from, to, name := &h.undo, &h.redo, "Undo"
+	if redo {
+		from, to, name = &h.redo, &h.undo, "Redo"
+	}
+	if len(*from) == 0 {
+		e.StatusMsg = "Already at oldest change"
+		if redo {
+			e.StatusMsg = "Already at newest change"
+		}
+		return
+	}
+	edit := (*from)[len(*from)-1]
+	*from = (*from)[:len(*from)-1]
+	h.Data = slices.Replace(h.Data, edit.off, edit.off+len(edit.new), edit.old...)
+	*to = append(*to, hexEdit{off: edit.off, old: edit.new, new: edit.old, cursor: h.Cursor})
+	h.moveTo(edit.cursor)
+	e.StatusMsg = fmt.Sprintf("%s (%d more)", name, len(*from))
+	e.ScrollHexToCursor(e.CurrentPane())
+}
+
+// HandleHexKey handles navigation and editing in a binary buffer. Other
+// keys, like save, search and the command bar, go through the keymap.
+func (e *Editor) HandleHexKey(ev *tcell.EventKey) bool {
+	buf := e.CurrentBuffer()
+	pane := e.CurrentPane()
+	h := buf.Hex
+	perRow := h.bytesPerRow()
+
+	if e.pendingKeys != "" || ev.Modifiers()&(tcell.ModCtrl|tcell.ModAlt) != 0 {
+		e.HandleMappedKey(ev)
+		return true
+	}
+	switch ev.Key() {
+	case tcell.KeyUp:
+		if h.Cursor >= perRow {
+			h.moveTo(h.Cursor - perRow)
+		}
+	case tcell.KeyDown:
+		if h.Cursor+perRow <= len(h.Data) {
+			h.moveTo(h.Cursor + perRow)
+		}
+	case tcell.KeyLeft:
+		if !h.ASCII && h.Nibble == 1 {
+			h.Nibble = 0
+		} else {
+			h.moveTo(h.Cursor - 1)
+		}
+	case tcell.KeyRight:
+		h.moveTo(h.Cursor + 1)
+	case tcell.KeyHome:
+		h.moveTo(h.Cursor - h.Cursor%perRow)
+	case tcell.KeyEnd:
+		h.moveTo(h.Cursor - h.Cursor%perRow + perRow - 1)
+	case tcell.KeyPgUp:
+		h.moveTo(h.Cursor - perRow*max(1, pane.Height-1))
+	case tcell.KeyPgDn:
+		h.moveTo(h.Cursor + perRow*max(1, pane.Height-1))
+
+	case tcell.KeyTab:
+		h.ASCII = !h.ASCII
+		h.Nibble = 0
+
+	case tcell.KeyInsert:
+		h.Insert = !h.Insert
+		if h.Insert {
+			e.StatusMsg = "Insert mode"
+		} else {
+			e.StatusMsg = "Overwrite mode"
+		}
+
+	case tcell.KeyDelete:
+		if e.readOnly(buf) {
+			return true
+		}
+		if h.Cursor < len(h.Data) {
+			h.replace(h.Cursor, 1, nil)
+			h.Nibble = 0
+		}
+
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if e.readOnly(buf) {
+			return true
+		}
+		if h.Cursor > 0 {
+			h.replace(h.Cursor-1, 1, nil)
+			h.moveTo(h.Cursor - 1)
+		}
+
+	case tcell.KeyRune:
+		if ev.Rune() == 'n' && len(e.SearchMatches) > 0 && !h.ASCII {
+			e.SearchIndex = (e.SearchIndex + 1) % len(e.SearchMatches)
+			e.JumpToSearchMatch()
+			return true
+		}
+		if ev.Rune() == 'N' && len(e.SearchMatches) > 0 && !h.ASCII {
+			e.SearchIndex = (e.SearchIndex - 1 + len(e.SearchMatches)) % len(e.SearchMatches)
+			e.JumpToSearchMatch()
+			return true
+		}
+		if e.readOnly(buf) {
+			return true
+		}
+		e.hexType(h, ev.Rune())
+
+	default:
+		e.HandleMappedKey(ev)
+		return true
+	}
+
+	e.ScrollHexToCursor(pane)
+	return true
+}
+
+// hexKeys are the keys that do in a binary buffer what actions bound to
+// other keys do in text.
+var hexKeys = map[string]tcell.Key{
+	"move.up": tcell.KeyUp, "move.down": tcell.KeyDown,
+	"move.left": tcell.KeyLeft, "move.right": tcell.KeyRight,
+	"move.linestart": tcell.KeyHome, "move.home": tcell.KeyHome,
+	"move.lineend": tcell.KeyEnd, "move.end": tcell.KeyEnd,
+	"delete.back": tcell.KeyBackspace2, "delete.forward": tcell.KeyDelete,
+	"insert.tab": tcell.KeyTab,
+}
+
+// runHexAction runs a text action in a binary buffer as the key that does
+// the same there. Actions with nothing to do there, like newline, are left
+// out.
+func (e *Editor) runHexAction(action string) {
+	if key, ok := hexKeys[action]; ok {
+		e.HandleHexKey(tcell.NewEventKey(key, 0, tcell.ModNone))
+	}
+}
+
+func (e *Editor) hexType(h *hexView, r rune) {
+	var value byte
+	if h.ASCII {
+		if r < 0x20 || r >= 0x7f {
+			e.StatusMsg = "Only printable ASCII can be typed here"
+			return
+		}
+		value = byte(r)
+	} else {
+		v, err := strconv.ParseUint(string(r), 16, 8)
+		if err != nil {
+			e.StatusMsg = "Not a hex digit"
+			return
+		}
+		value = byte(v)
+	}
+
+	n, old := 0, byte(0)
+	if h.Cursor < len(h.Data) && !(h.Insert && h.Nibble == 0) {
+		n, old = 1, h.Data[h.Cursor]
+	}
+	switch {
+	case h.ASCII:
+		h.replace(h.Cursor, n, []byte{value})
+		h.moveTo(h.Cursor + 1)
+	case h.Nibble == 0:
+		h.replace(h.Cursor, n, []byte{value<<4 | old&0x0f})
+		h.Nibble = 1
+	default:
+		h.replace(h.Cursor, n, []byte{old&0xf0 | value})
+		h.moveTo(h.Cursor + 1)
+	}
+}
+
+// parseHexQuery turns "de ad be ef" or "0xdeadbeef" into bytes. Anything else
+// is searched for as literal text.
+func parseHexQuery(query string) []byte {
+	var digits string
+	if strings.HasPrefix(query, "0x") {
+		digits = query[2:]
+	} else if strings.Contains(query, " ") {
+		for _, field := range strings.Fields(query) {
+			if len(field) != 2 {
+				return []byte(query)
+			}
+		}
+		digits = strings.ReplaceAll(query, " ", "")
+	} else {
+		return []byte(query)
+	}
+	data, err := hex.DecodeString(digits)
+	if err != nil || len(data) == 0 {
+		return []byte(query)
+	}
+	return data
+}
+
+// ExecuteHexSearch records matches as byte offsets in SearchMatch.Col.
+func (e *Editor) ExecuteHexSearch() {
+	h := e.CurrentBuffer().Hex
+	needle := parseHexQuery(e.SearchQuery)
+	e.SearchMatches = nil
+
+	start := 0
+	for {
+		idx := bytes.Index(h.Data[start:], needle)
+		if idx == -1 {
+			break
+		}
+		e.SearchMatches = append(e.SearchMatches, SearchMatch{Col: start + idx, Len: len(needle)})
+		start += idx + 1
+	}
+}
+
+func (e *Editor) GotoOffset(arg string) {
+	buf := e.CurrentBuffer()
+	off, err := strconv.ParseInt(arg, 0, 64)
+	if err != nil {
+		e.StatusMsg = "Invalid offset"
+		return
+	}
+	buf.Hex.moveTo(int(off))
+	e.ScrollHexToCursor(e.CurrentPane())
+	e.StatusMsg = fmt.Sprintf("Offset 0x%x", buf.Hex.Cursor)
+}
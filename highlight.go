@@ -0,0 +1,275 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/gdamore/tcell/v2"
+)
+
+const (
+	checkpointInterval = 32 // lines between lexer checkpoints
+	// Lines lexed past the requested range, so a construct that closes
+	// below the viewport is still recognised as one.
+	highlightLookahead = 1000
+)
+
+// lexCheckpoint records the lexer state at the start of Line, taken as the
+// type of the token that contains the preceding newline. chroma can only
+// start lexing in its root state, so only checkpoints at rest are resumed
+// from; all of them are used to tell when re-lexing has converged with what
+// was there before an edit.
+type lexCheckpoint struct {
+	Line  int
+	State chroma.TokenType
+}
+
+func atRest(state chroma.TokenType) bool {
+	return state == chroma.Text || state == chroma.TextWhitespace || state == chroma.CommentSingle
+}
+
+func tokenStyle(style *chroma.Style, t chroma.TokenType) tcell.Style {
+	entry := style.Get(t)
+	result := tcell.StyleDefault.Foreground(chromaToTcell(entry.Colour)).Background(chromaToTcell(entry.Background))
+	if entry.Bold == chroma.Yes {
+		result = result.Bold(true)
+	}
+	if entry.Italic == chroma.Yes {
+		result = result.Italic(true)
+	}
+	return result
+}
+
+// highlightJob lexes Lines, a copy of the buffer starting at line First,
+// which must be a checkpoint at rest. It stops before line Stop, or as soon
+// as it reaches a line past Dirty whose old checkpoint in Old matches the
+// new state and whose tokens are still Valid.
+type highlightJob struct {
+	Lexer chroma.Lexer
+	Style *chroma.Style
+	First int
+	Dirty int
+	Stop  int
+	Lines []string
+	Old   []lexCheckpoint
+	Valid []bool // indexed like Lines
+}
+
+type highlightResult struct {
+	First       int
+	Tokens      [][]TokenInfo // one entry per line from First
+	Checkpoints []lexCheckpoint
+	Converged   bool // lines after the result still hold valid tokens
+}
+
+func (j *highlightJob) run() highlightResult {
+	res := highlightResult{First: j.First}
+	last := min(j.Stop, j.First+len(j.Lines))
+	iterator, err := j.Lexer.Tokenise(nil, strings.Join(j.Lines, "\n"))
+	if err != nil {
+		// Leave the lines plain rather than trying them again
+		for i := j.First; i < last; i++ {
+			res.Tokens = append(res.Tokens, []TokenInfo{})
+		}
+		return res
+	}
+
+	styles := make(map[chroma.TokenType]tcell.Style)
+	line := j.First
+	col := 0
+	current := []TokenInfo{}
+	old := 0
+
+	for token := iterator(); token != chroma.EOF; token = iterator() {
+		style, ok := styles[token.Type]
+		if !ok {
+			style = tokenStyle(j.Style, token.Type)
+			styles[token.Type] = style
+		}
+
+		for i, part := range strings.Split(token.Value, "\n") {
+			if i > 0 {
+				res.Tokens = append(res.Tokens, current)
+				current = []TokenInfo{}
+				line++
+				col = 0
+				if line >= last {
+					return res
+				}
+				for old < len(j.Old) && j.Old[old].Line < line {
+					old++
+				}
+				if line > j.Dirty && old < len(j.Old) && j.Old[old].Line == line &&
+					j.Old[old].State == token.Type && atRest(token.Type) && j.Valid[line-j.First] {
+					res.Converged = true
+					return res
+				}
+				if line%checkpointInterval == 0 {
+					res.Checkpoints = append(res.Checkpoints, lexCheckpoint{Line: line, State: token.Type})
+				}
+			}
+			if n := utf8.RuneCountInString(part); n > 0 {
+				current = append(current, TokenInfo{Col: col, Len: n, Style: style, Type: token.Type})
+				col += n
+			}
+		}
+	}
+	if line < last {
+		res.Tokens = append(res.Tokens, current)
+	}
+	return res
+}
+
+// highlightEvent carries the result of a highlightJob run in the background.
+// Version is the buffer's Version when the job was taken; results for an
+// older version are dropped.
+type highlightEvent struct {
+	tcell.EventTime
+	Buffer  *Buffer
+	Version int
+	Result  highlightResult
+}
+
+// nextHighlightJob returns a job for the first line before end that has no
+// tokens, or nil if there is none. Lexing resumes from the nearest checkpoint
+// at rest and stops once the lexer state converges, so an edit only costs
+// the lines it actually affects. DirtyLineStart is the first line without
+// tokens, or -1.
+func (b *Buffer) nextHighlightJob(end int) *highlightJob {
+	if b.Lexer == nil || b.Style == nil || b.ANSI != nil || b.DirtyLineStart < 0 {
+		return nil
+	}
+	first := b.DirtyLineStart
+	for first < len(b.TokenCache) && b.TokenCache[first] != nil {
+		first++
+	}
+	if first >= len(b.TokenCache) {
+		b.DirtyLineStart = -1
+		return nil
+	}
+	b.DirtyLineStart = first
+	if first >= min(end, len(b.Lines)) {
+		return nil
+	}
+	return b.newHighlightJob(first, min(end, len(b.Lines)))
+}
+
+// HighlightUpTo makes sure every line before end has tokens, lexing on the
+// calling goroutine.
+func (b *Buffer) HighlightUpTo(end int) {
+	for job := b.nextHighlightJob(end); job != nil; job = b.nextHighlightJob(end) {
+		b.applyHighlight(job.run())
+	}
+}
+
+// ScheduleHighlight lexes the lines pane shows in the background. Only one
+// job per buffer runs at a time; once its result is in, the next Draw
+// schedules whatever is still missing.
+func (e *Editor) ScheduleHighlight(pane *Pane) {
+	buf := pane.Buffer
+	if buf.highlighting {
+		return
+	}
+	job := buf.nextHighlightJob(buf.OffsetY + pane.Height)
+	if job == nil {
+		return
+	}
+	buf.highlighting = true
+	ev := &highlightEvent{Buffer: buf, Version: buf.Version}
+	screen := e.Screen
+	go func() {
+		ev.Result = job.run()
+		ev.SetEventNow()
+		// Dropping it would leave the buffer waiting forever
+		screen.PostEventWait(ev)
+	}()
+}
+
+func (e *Editor) HandleHighlightEvent(ev *highlightEvent) {
+	buf := ev.Buffer
+	buf.highlighting = false
+	if ev.Version != buf.Version || buf.Lexer == nil || buf.ANSI != nil {
+		return
+	}
+	buf.applyHighlight(ev.Result)
+}
+
+func (b *Buffer) newHighlightJob(dirty, stop int) *highlightJob {
+	first := 0
+	oldStart := 0
+	for i := len(b.Checkpoints) - 1; i >= 0; i-- {
+		cp := b.Checkpoints[i]
+		if cp.Line <= dirty && atRest(cp.State) {
+			first = cp.Line
+			oldStart = i + 1
+			break
+		}
+	}
+
+	last := min(len(b.Lines), stop+highlightLookahead)
+	job := &highlightJob{
+		Lexer: b.Lexer,
+		Style: b.Style,
+		First: first,
+		Dirty: dirty,
+		Stop:  stop,
+		Lines: append([]string(nil), b.Lines[first:last]...),
+		Old:   append([]lexCheckpoint(nil), b.Checkpoints[oldStart:]...),
+		Valid: make([]bool, last-first),
+	}
+	for i := range job.Valid {
+		job.Valid[i] = b.TokenCache[first+i] != nil
+	}
+	return job
+}
+
+func (b *Buffer) applyHighlight(res highlightResult) {
+	end := res.First + len(res.Tokens)
+	copy(b.TokenCache[res.First:end], res.Tokens)
+
+	var checkpoints []lexCheckpoint
+	for _, cp := range b.Checkpoints {
+		if cp.Line <= res.First {
+			checkpoints = append(checkpoints, cp)
+		}
+	}
+	checkpoints = append(checkpoints, res.Checkpoints...)
+	if res.Converged {
+		for _, cp := range b.Checkpoints {
+			if cp.Line >= end {
+				checkpoints = append(checkpoints, cp)
+			}
+		}
+	} else {
+		// Whatever follows was lexed in a state that no longer holds
+		for i := end; i < len(b.TokenCache); i++ {
+			b.TokenCache[i] = nil
+		}
+	}
+	b.Checkpoints = checkpoints
+}
+
+// spliceHighlight keeps TokenCache and Checkpoints lined up with Lines after
+// delta lines were inserted (delta > 0) or removed (delta < 0) right after
+// line at.
+func (b *Buffer) spliceHighlight(at, delta int) {
+	if delta > 0 {
+		b.TokenCache = append(b.TokenCache[:at+1], append(make([][]TokenInfo, delta), b.TokenCache[at+1:]...)...)
+	} else if delta < 0 {
+		b.TokenCache = append(b.TokenCache[:at+1], b.TokenCache[at+1-delta:]...)
+	}
+
+	checkpoints := b.Checkpoints[:0]
+	for _, cp := range b.Checkpoints {
+		switch {
+		case cp.Line <= at:
+		case delta < 0 && cp.Line <= at-delta:
+			continue
+		default:
+			cp.Line += delta
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+	b.Checkpoints = checkpoints
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/chroma/v2"
+)
+
+func newHighlightedBuffer(t *testing.T, filename string, lines []string) *Buffer {
+	t.Helper()
+	b := NewBuffer()
+	b.Filename = filename
+	b.Lines = lines
+	b.SetupHighlighting()
+	return b
+}
+
+func codeLines(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("\tx%d := %d", i, i)
+	}
+	return lines
+}
+
+func assertStyle(t *testing.T, b *Buffer, line int, want chroma.TokenType) {
+	t.Helper()
+	if got, exp := b.GetStyleAt(line, 1), tokenStyle(b.Style, want); got != exp {
+		t.Errorf("line %d %q: style %v, want %v style %v", line, b.Lines[line], got, want, exp)
+	}
+}
+
+func TestHighlightLongBlockComment(t *testing.T) {
+	lines := append([]string{"package main", "/*"}, codeLines(150)...)
+	lines = append(lines, "*/", "func main() {}")
+	b := newHighlightedBuffer(t, "main.go", lines)
+
+	// Only the lines in view get lexed
+	b.HighlightUpTo(40)
+	if b.TokenCache[39] == nil || b.TokenCache[40] != nil {
+		t.Fatalf("expected tokens for exactly the first 40 lines")
+	}
+
+	// Editing 100 lines below the opening /* must keep the comment style
+	b.HighlightUpTo(130)
+	b.Lines[110] = "y" + b.Lines[110]
+	b.MarkDirtyLines(110, 110)
+	b.HighlightUpTo(130)
+	assertStyle(t, b, 110, chroma.CommentMultiline)
+	assertStyle(t, b, 129, chroma.CommentMultiline)
+
+	b.HighlightUpTo(len(b.Lines))
+	assertStyle(t, b, len(b.Lines)-1, chroma.KeywordDeclaration)
+}
+
+func TestHighlightLongRawString(t *testing.T) {
+	lines := append([]string{"package main", "var s = `"}, codeLines(120)...)
+	lines = append(lines, "`", "var n = 1")
+	b := newHighlightedBuffer(t, "main.go", lines)
+	b.HighlightUpTo(len(b.Lines))
+
+	b.Lines[90] = "more" + b.Lines[90]
+	b.MarkDirtyLines(90, 90)
+	b.HighlightUpTo(len(b.Lines))
+	assertStyle(t, b, 90, chroma.LiteralString)
+	assertStyle(t, b, len(b.Lines)-1, chroma.KeywordDeclaration)
+}
+
+func TestHighlightPythonDocstring(t *testing.T) {
+	lines := []string{"def f():", `    """`}
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprintf("    docs line %d", i))
+	}
+	lines = append(lines, `    """`, "    return 1")
+	b := newHighlightedBuffer(t, "f.py", lines)
+	b.HighlightUpTo(len(b.Lines))
+
+	b.Lines[80] += " edited"
+	b.MarkDirtyLines(80, 80)
+	b.HighlightUpTo(len(b.Lines))
+	assertStyle(t, b, 80, chroma.LiteralStringDoc)
+}
+
+func TestHighlightCommentOpenedAboveViewport(t *testing.T) {
+	lines := append([]string{"package main"}, codeLines(400)...)
+	lines[380] = "*/"
+	b := newHighlightedBuffer(t, "main.go", lines)
+	b.HighlightUpTo(len(b.Lines))
+	assertStyle(t, b, 300, chroma.NameOther)
+
+	// Opening a comment far above the viewport changes what is shown in it,
+	// even though it only closes further down
+	b.Lines[5] = "/*" + b.Lines[5]
+	b.MarkDirtyLines(5, 5)
+	b.HighlightUpTo(320)
+	assertStyle(t, b, 300, chroma.CommentMultiline)
+	if b.TokenCache[330] != nil {
+		t.Errorf("tokens below the viewport should have been dropped")
+	}
+
+	// Closing it again restores the code below
+	b.Lines[5] = strings.TrimPrefix(b.Lines[5], "/*")
+	b.MarkDirtyLines(5, 5)
+	b.HighlightUpTo(320)
+	assertStyle(t, b, 300, chroma.NameOther)
+}
+
+func TestHighlightConvergesAfterLocalEdit(t *testing.T) {
+	b := newHighlightedBuffer(t, "main.go", append([]string{"package main"}, codeLines(2000)...))
+	b.HighlightUpTo(len(b.Lines))
+	if b.DirtyLineStart != -1 {
+		t.Fatalf("DirtyLineStart = %d after full highlight", b.DirtyLineStart)
+	}
+
+	b.Lines[10] = "\tchanged := 1"
+	b.MarkDirtyLines(10, 10)
+	b.HighlightUpTo(50)
+	if b.TokenCache[1500] == nil {
+		t.Errorf("a local edit should not invalidate distant lines")
+	}
+	if b.DirtyLineStart != -1 {
+		t.Errorf("DirtyLineStart = %d, want -1", b.DirtyLineStart)
+	}
+}
+
+func TestHighlightLineInsertAndDelete(t *testing.T) {
+	lines := append([]string{"package main", "/*"}, codeLines(100)...)
+	lines = append(lines, "*/", "var n = 1")
+	b := newHighlightedBuffer(t, "main.go", lines)
+	b.HighlightUpTo(len(b.Lines))
+
+	// Split a line inside the comment, as Enter does
+	b.Lines = append(b.Lines[:51], append([]string{"split"}, b.Lines[51:]...)...)
+	b.MarkDirtyLines(50, 51)
+	if len(b.TokenCache) != len(b.Lines) {
+		t.Fatalf("TokenCache has %d lines, buffer %d", len(b.TokenCache), len(b.Lines))
+	}
+	b.HighlightUpTo(len(b.Lines))
+	assertStyle(t, b, 51, chroma.CommentMultiline)
+	assertStyle(t, b, len(b.Lines)-1, chroma.KeywordDeclaration)
+
+	// Join it back, as Backspace does
+	b.Lines[50] += b.Lines[51]
+	b.Lines = append(b.Lines[:51], b.Lines[52:]...)
+	b.MarkDirtyLines(50, 50)
+	b.HighlightUpTo(len(b.Lines))
+	assertStyle(t, b, 50, chroma.CommentMultiline)
+	assertStyle(t, b, len(b.Lines)-1, chroma.KeywordDeclaration)
+	for i, cp := range b.Checkpoints {
+		if i > 0 && cp.Line <= b.Checkpoints[i-1].Line {
+			t.Fatalf("checkpoints out of order: %v", b.Checkpoints)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const commandHistorySize = 100
+
+// sessionHistory is what accela keeps between sessions in history.json,
+// next to the config file.
+type sessionHistory struct {
+	Commands  []string          `json:"commands"`
+	Registers map[string]string `json:"registers,omitempty"` // with saveregisters on
+}
+
+func historyPath() string {
+	dir := configDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "history.json")
+}
+
+func (e *Editor) loadHistory() error {
+	path := historyPath()
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	var h sessionHistory
+	if err == nil {
+		err = json.Unmarshal(data, &h)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	e.commandHistory = h.Commands
+	if e.Config.SaveRegisters {
+		for name, text := range h.Registers {
+			if r := []rune(name); len(r) == 1 && isRegister(r[0]) {
+				e.setRegister(r[0], text)
+			}
+		}
+	}
+	return nil
+}
+
+func (e *Editor) saveHistory() error {
+	path := historyPath()
+	if path == "" {
+		return nil
+	}
+	h := sessionHistory{Commands: e.commandHistory}
+	if e.Config.SaveRegisters && len(e.registers) > 0 {
+		h.Registers = map[string]string{}
+		for r, text := range e.registers {
+			h.Registers[string(r)] = text
+		}
+	}
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// addCommandHistory remembers cmd as the newest command, moving it there if
+// it was already run.
+func (e *Editor) addCommandHistory(cmd string) {
+	if cmd == "" {
+		return
+	}
+	for i, old := range e.commandHistory {
+		if old == cmd {
+			e.commandHistory = append(e.commandHistory[:i], e.commandHistory[i+1:]...)
+			break
+		}
+	}
+	e.commandHistory = append(e.commandHistory, cmd)
+	if len(e.commandHistory) > commandHistorySize {
+		e.commandHistory = e.commandHistory[1:]
+	}
+}
+
+// recallCommand steps through the command history, dir -1 to older
+// commands and 1 back towards the one being typed.
+func (e *Editor) recallCommand(dir int) {
+	i := e.historyIndex + dir
+	if i < 0 || i > len(e.commandHistory) {
+		return
+	}
+	if e.historyIndex == len(e.commandHistory) {
+		e.commandDraft = e.Command
+	}
+	e.historyIndex = i
+	if i == len(e.commandHistory) {
+		e.Command = e.commandDraft
+	} else {
+		e.Command = e.commandHistory[i]
+	}
+}
+
+// exit saves the history and leaves accela.
+func (e *Editor) exit() {
+	err := e.saveHistory()
+	e.Screen.Fini()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving history: %v\n", err)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// indentRule says how a language's lines indent, beyond keeping the
+// indentation of the line before.
+type indentRule struct {
+	open        string   // a line ending in one of these indents the next one
+	close       string   // typed first on a line, one of these dedents it
+	dedentAfter []string // the line after a statement starting with one of these dedents, like Python's return
+}
+
+var (
+	braceIndent  = indentRule{open: "{([", close: "})]"}
+	pythonIndent = indentRule{
+		open:        ":{([",
+		close:       "})]",
+		dedentAfter: []string{"return", "pass", "break", "continue", "raise"},
+	}
+)
+
+// indentRules are keyed by chroma lexer name. Languages not listed only
+// keep the indentation.
+var indentRules = map[string]indentRule{
+	"Go": braceIndent, "C": braceIndent, "C++": braceIndent, "C#": braceIndent,
+	"Java": braceIndent, "Kotlin": braceIndent, "Scala": braceIndent, "Swift": braceIndent,
+	"Dart": braceIndent, "Rust": braceIndent, "Zig": braceIndent, "PHP": braceIndent,
+	"JavaScript": braceIndent, "TypeScript": braceIndent, "react": braceIndent,
+	"JSON": braceIndent, "CSS": braceIndent, "SCSS": braceIndent, "Nix": braceIndent,
+	"HCL": braceIndent, "Terraform": braceIndent, "Bash": braceIndent, "Lua": braceIndent,
+	"Python": pythonIndent, "Python 2": pythonIndent,
+	"YAML": {open: ":"},
+}
+
+func (b *Buffer) indentRule() (indentRule, bool) {
+	if b.Lexer == nil {
+		return indentRule{}, false
+	}
+	rule, ok := indentRules[b.Lexer.Config().Name]
+	return rule, ok
+}
+
+// tabWidth, shiftWidth and expandTab are buf's own settings, detected from
+// its file or set by .editorconfig or setlocal, or else the config's.
+func (e *Editor) tabWidth(buf *Buffer) int {
+	if buf.TabWidth > 0 {
+		return buf.TabWidth
+	}
+	return e.Config.TabWidth
+}
+
+// shiftWidth is how many columns one level of indentation takes.
+func (e *Editor) shiftWidth(buf *Buffer) int {
+	switch {
+	case buf.ShiftWidth > 0:
+		return buf.ShiftWidth
+	case buf.TabWidth == 0 && e.Config.ShiftWidth > 0:
+		return e.Config.ShiftWidth
+	}
+	return e.tabWidth(buf)
+}
+
+func (e *Editor) expandTab(buf *Buffer) bool {
+	if buf.ExpandTab != nil {
+		return *buf.ExpandTab
+	}
+	return e.Config.ExpandTab
+}
+
+// indentUnit is what one level of indentation is: a tab, or shiftwidth
+// spaces with expandtab.
+func (e *Editor) indentUnit(buf *Buffer) string {
+	if e.expandTab(buf) {
+		return strings.Repeat(" ", e.shiftWidth(buf))
+	}
+	return "\t"
+}
+
+// detectIndent sets expandtab and shiftwidth for b from how its lines are
+// indented: tabs or spaces, whichever more lines start with, and for
+// spaces the step most often seen between one line and the next.
+func (b *Buffer) detectIndent() {
+	tabs, spaces := 0, 0
+	steps := map[int]int{}
+	prev := 0
+	for _, line := range b.Lines {
+		indent := leadingSpace(line)
+		if indent == line {
+			// Blank lines say nothing
+			continue
+		}
+		if strings.HasPrefix(indent, "\t") {
+			tabs++
+			prev = 0
+			continue
+		}
+		if rest := line[len(indent):]; indent != "" && !strings.HasPrefix(rest, "*") {
+			// Lines inside C block comments are indented one space
+			spaces++
+		}
+		if n := len(indent); n != prev && !strings.Contains(indent, "\t") {
+			steps[max(n, prev)-min(n, prev)]++
+			prev = n
+		}
+	}
+	if tabs == 0 && spaces == 0 {
+		return
+	}
+	expand := spaces > tabs
+	b.ExpandTab = &expand
+	if !expand {
+		return
+	}
+	best := 0
+	for _, step := range []int{2, 4, 8, 3} {
+		if steps[step] > steps[best] {
+			best = step
+		}
+	}
+	if best > 0 {
+		b.ShiftWidth = best
+	}
+}
+
+func leadingSpace(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// dedent takes one level off indent.
+func (e *Editor) dedent(buf *Buffer, indent string) string {
+	if strings.HasSuffix(indent, "\t") {
+		return indent[:len(indent)-1]
+	}
+	trimmed := strings.TrimRight(indent, " ")
+	return indent[:max(len(trimmed), len(indent)-e.shiftWidth(buf))]
+}
+
+// newlineIndent works out the indentation of a line split off after
+// before. between reports that the cursor sits between an opening bracket
+// and its closing one, which then go on lines of their own.
+func (e *Editor) newlineIndent(buf *Buffer, before, after string) (indent string, between bool) {
+	indent = leadingSpace(before)
+	rule, ok := buf.indentRule()
+	if !ok {
+		return indent, false
+	}
+	line := strings.TrimRight(before, " \t")
+	if line == "" {
+		return indent, false
+	}
+	if last := line[len(line)-1:]; strings.Contains(rule.open, last) {
+		after = strings.TrimLeft(after, " \t")
+		i := strings.Index(rule.open, last)
+		between = i < len(rule.close) && strings.HasPrefix(after, rule.close[i:i+1])
+		return indent + e.indentUnit(buf), between
+	}
+	word := strings.TrimLeft(line, " \t")
+	if end := strings.IndexFunc(word, func(r rune) bool { return !unicode.IsLetter(r) }); end >= 0 {
+		word = word[:end]
+	}
+	if slices.Contains(rule.dedentAfter, word) {
+		return e.dedent(buf, indent), false
+	}
+	return indent, false
+}
+
+// dedentClosing takes a level off the cursor's line when r, a closing
+// bracket, is typed first on it.
+func (e *Editor) dedentClosing(buf *Buffer, r rune) {
+	rule, ok := buf.indentRule()
+	if !ok || !e.Config.AutoIndent || !strings.ContainsRune(rule.close, r) {
+		return
+	}
+	runes := []rune(buf.Lines[buf.CursorY])
+	before := string(runes[:min(buf.CursorX, len(runes))])
+	if before == "" || strings.TrimLeft(before, " \t") != "" {
+		return
+	}
+	indent := e.dedent(buf, before)
+	buf.Lines[buf.CursorY] = indent + string(runes[len([]rune(before)):])
+	buf.CursorX = len([]rune(indent))
+}
+
+// selectedLines returns the lines the selection touches, or the cursor's
+// line. A selection ending at the start of a line leaves that line out.
+func (b *Buffer) selectedLines() (first, last int) {
+	if !b.Selection.Active {
+		return b.CursorY, b.CursorY
+	}
+	start, end := b.mainCursor().span()
+	if end.Line > start.Line && end.Col == 0 {
+		end.Line--
+	}
+	return start.Line, end.Line
+}
+
+// ShiftLines indents the selected lines, or the cursor's, by one level, or
+// dedents them. The selection stays on the same text.
+func (e *Editor) ShiftLines(more bool) {
+	buf := e.CurrentBuffer()
+	if e.readOnly(buf) {
+		return
+	}
+	first, last := buf.selectedLines()
+	unit := e.indentUnit(buf)
+	shift := func(col, delta int) int {
+		if col == 0 && delta > 0 {
+			return 0
+		}
+		return max(0, col+delta)
+	}
+	for y := first; y <= last; y++ {
+		line := buf.Lines[y]
+		var changed string
+		if more {
+			if line == "" {
+				continue
+			}
+			changed = unit + line
+		} else {
+			indent := leadingSpace(line)
+			changed = e.dedent(buf, indent) + line[len(indent):]
+		}
+		delta := len([]rune(changed)) - len([]rune(line))
+		if delta == 0 {
+			continue
+		}
+		buf.Lines[y] = changed
+		s := &buf.Selection
+		if s.Active && s.StartLine == y {
+			s.StartCol = shift(s.StartCol, delta)
+		}
+		if s.Active && s.EndLine == y {
+			s.EndCol = shift(s.EndCol, delta)
+		}
+		if buf.CursorY == y {
+			buf.CursorX = shift(buf.CursorX, delta)
+		}
+	}
+	buf.MarkDirtyLines(first, last)
+}
+
+func (e *Editor) IndentLines() {
+	e.ShiftLines(true)
+}
+
+func (e *Editor) DedentLines() {
+	e.ShiftLines(false)
+}
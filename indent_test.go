@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+// flagName is "true" or "false" for a buffer's own setting, or "unset".
+func flagName(flag *bool) string {
+	if flag == nil {
+		return "unset"
+	}
+	if *flag {
+		return "true"
+	}
+	return "false"
+}
+
+func TestDetectIndent(t *testing.T) {
+	tests := []struct {
+		name      string
+		lines     []string
+		expandTab string // "unset" when nothing could be told
+		shift     int
+	}{
+		{"no indentation", []string{"a", "b", ""}, "unset", 0},
+		{"blank lines only", []string{"", "  ", "\t"}, "unset", 0},
+		{"tabs", []string{"func f() {", "\tx()", "\tif y {", "\t\tz()", "\t}", "}"}, "false", 0},
+		{"four spaces", []string{"def f():", "    x()", "    if y:", "        z()", "    w()"}, "true", 4},
+		{"two spaces", []string{"a:", "  b:", "    c: 1", "  d: 2", "e: 3"}, "true", 2},
+		{"eight spaces", []string{"a", "        b", "a"}, "true", 8},
+		{"mostly tabs", []string{"a", "\tb", "\tc", "    d"}, "false", 0},
+		{"block comment under tabs", []string{"/*", " * a", " * b", " */", "f() {", "\tx()", "}"}, "false", 0},
+		{"odd step left alone", []string{"a", " b", "  c", "   d"}, "true", 0},
+		{"step back to tabs", []string{"a", "  b", "\tc", "  d"}, "true", 2},
+	}
+	for _, tt := range tests {
+		b := NewBuffer()
+		b.Lines = tt.lines
+		b.detectIndent()
+		expandTab := flagName(b.ExpandTab)
+		if expandTab != tt.expandTab || b.ShiftWidth != tt.shift {
+			t.Errorf("%s: expandtab %s shiftwidth %d, want %s %d", tt.name, expandTab, b.ShiftWidth, tt.expandTab, tt.shift)
+		}
+	}
+}
+
+func TestInsertTab(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		col       int
+		expandTab bool
+		want      string
+		wantCol   int
+	}{
+		{"tab", "ab", 1, false, "a\tb", 2},
+		{"spaces", "ab", 1, true, "a   b", 4},
+		{"spaces after wide runes", "éñb", 2, true, "éñ  b", 4},
+		{"spaces before wide runes", "éñ", 1, true, "é   ñ", 4},
+		{"tab after wide runes", "éñ", 1, false, "é\tñ", 2},
+	}
+	for _, tt := range tests {
+		e := testEditor(t, tt.text)
+		e.Config.ExpandTab = tt.expandTab
+		buf := e.CurrentBuffer()
+		buf.CursorX = tt.col
+		typeKeys(e, "\t")
+		if got := buf.Lines[0]; got != tt.want || buf.CursorX != tt.wantCol {
+			t.Errorf("%s: %q cursor %d, want %q %d", tt.name, got, buf.CursorX, tt.want, tt.wantCol)
+		}
+	}
+}
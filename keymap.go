@@ -0,0 +1,438 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Key chords are written like "ctrl+q", "alt+left", "ctrl+alt+shift+f5" or
+// a single rune such as "n". A binding may be a sequence of chords
+// separated by spaces, e.g. "ctrl+k ctrl+d".
+
+var keyNames = map[tcell.Key]string{
+	tcell.KeyEnter:      "enter",
+	tcell.KeyTab:        "tab",
+	tcell.KeyBacktab:    "backtab",
+	tcell.KeyBackspace:  "backspace",
+	tcell.KeyBackspace2: "backspace",
+	tcell.KeyEscape:     "esc",
+	tcell.KeyDelete:     "delete",
+	tcell.KeyInsert:     "insert",
+	tcell.KeyUp:         "up",
+	tcell.KeyDown:       "down",
+	tcell.KeyLeft:       "left",
+	tcell.KeyRight:      "right",
+	tcell.KeyHome:       "home",
+	tcell.KeyEnd:        "end",
+	tcell.KeyPgUp:       "pgup",
+	tcell.KeyPgDn:       "pgdn",
+}
+
+var keyAliases = map[string]string{
+	"escape":    "esc",
+	"return":    "enter",
+	"del":       "delete",
+	"bs":        "backspace",
+	"pageup":    "pgup",
+	"pagedown":  "pgdn",
+	"shift+tab": "backtab",
+}
+
+func init() {
+	for i := 0; i < 12; i++ {
+		keyNames[tcell.KeyF1+tcell.Key(i)] = fmt.Sprintf("f%d", i+1)
+	}
+}
+
+func withModifiers(mods tcell.ModMask, name string) string {
+	if mods&tcell.ModShift != 0 {
+		name = "shift+" + name
+	}
+	if mods&tcell.ModAlt != 0 {
+		name = "alt+" + name
+	}
+	if mods&tcell.ModCtrl != 0 {
+		name = "ctrl+" + name
+	}
+	return name
+}
+
+// chordName names ev the way bindings are written.
+func chordName(ev *tcell.EventKey) string {
+	key, mods := ev.Key(), ev.Modifiers()
+	switch {
+	case key == tcell.KeyRune:
+		name := string(ev.Rune())
+		if ev.Rune() == ' ' {
+			name = "space"
+		}
+		// Shift is already in the rune
+		return withModifiers(mods&^tcell.ModShift, name)
+	case key >= tcell.KeyCtrlA && key <= tcell.KeyCtrlZ:
+		return withModifiers(mods|tcell.ModCtrl, string(rune('a'+key-tcell.KeyCtrlA)))
+	case key == tcell.KeyCtrlSpace:
+		return withModifiers(mods|tcell.ModCtrl, "space")
+	case key == tcell.KeyBacktab:
+		return withModifiers(mods&^tcell.ModShift, "backtab")
+	}
+	if name, ok := keyNames[key]; ok {
+		return withModifiers(mods, name)
+	}
+	// Control characters as terminals send them, e.g. 0x11 for ctrl+q
+	if key < ' ' && ev.Rune() != 0 {
+		return withModifiers(mods|tcell.ModCtrl, string(ev.Rune()))
+	}
+	return strings.ToLower(ev.Name())
+}
+
+// parseKeys normalizes a binding written by the user so it compares equal
+// to what chordName produces.
+func parseKeys(keys string) (string, error) {
+	var chords []string
+	for _, chord := range strings.Fields(keys) {
+		if alias, ok := keyAliases[strings.ToLower(chord)]; ok {
+			chord = alias
+		}
+		parts := strings.Split(chord, "+")
+		name := parts[len(parts)-1]
+		if name == "" && len(parts) > 1 {
+			// "ctrl++"
+			name = "+"
+			parts = parts[:len(parts)-1]
+		}
+		if len([]rune(name)) > 1 {
+			name = strings.ToLower(name)
+			if alias, ok := keyAliases[name]; ok {
+				name = alias
+			}
+		}
+		var mods tcell.ModMask
+		for _, mod := range parts[:len(parts)-1] {
+			switch strings.ToLower(mod) {
+			case "ctrl", "c":
+				mods |= tcell.ModCtrl
+			case "alt", "meta", "m":
+				mods |= tcell.ModAlt
+			case "shift", "s":
+				mods |= tcell.ModShift
+			default:
+				return "", fmt.Errorf("unknown modifier %q in %q", mod, chord)
+			}
+		}
+		if mods&tcell.ModCtrl != 0 && len([]rune(name)) == 1 {
+			// Terminals cannot tell ctrl+Q from ctrl+q
+			name = strings.ToLower(name)
+		} else if mods&tcell.ModShift != 0 && len([]rune(name)) == 1 {
+			// Shift comes in the rune, as chordName writes it
+			name = strings.ToUpper(name)
+			mods &^= tcell.ModShift
+		}
+		chords = append(chords, withModifiers(mods, name))
+	}
+	if len(chords) == 0 {
+		return "", fmt.Errorf("no keys given")
+	}
+	return strings.Join(chords, " "), nil
+}
+
+// keyProfiles are the sets of bindings the keys setting picks from. vi
+// mode keeps the default bindings for what it does not handle itself.
+var keyProfiles = map[string]func() map[string]string{
+	"default": defaultKeymap,
+	"vi":      viKeymap,
+	"emacs":   emacsKeymap,
+}
+
+// profileKeymap returns the bindings of the configured profile.
+func (e *Editor) profileKeymap() map[string]string {
+	if profile, ok := keyProfiles[e.Config.Keys]; ok {
+		return profile()
+	}
+	return defaultKeymap()
+}
+
+func defaultKeymap() map[string]string {
+	return map[string]string{
+		"esc":             "cancel",
+		"ctrl+w":          "pane.next",
+		"ctrl+c":          "clipboard.copy",
+		"ctrl+v":          "clipboard.paste",
+		"ctrl+x":          "clipboard.cut",
+		"ctrl+q":          "quit",
+		"ctrl+s":          "save",
+		"ctrl+e":          "command",
+		"ctrl+f":          "search",
+		"up":              "move.up",
+		"down":            "move.down",
+		"left":            "move.left",
+		"right":           "move.right",
+		"alt+left":        "move.wordleft",
+		"alt+right":       "move.wordright",
+		"ctrl+up":         "select.up",
+		"ctrl+down":       "select.down",
+		"ctrl+left":       "select.left",
+		"ctrl+right":      "select.right",
+		"ctrl+alt+left":   "select.wordleft",
+		"ctrl+alt+right":  "select.wordright",
+		"home":            "move.home",
+		"end":             "move.end",
+		"pgup":            "move.pageup",
+		"pgdn":            "move.pagedown",
+		"ctrl+home":       "move.top",
+		"ctrl+end":        "move.bottom",
+		"shift+home":      "select.home",
+		"shift+end":       "select.end",
+		"ctrl+pgup":       "select.pageup",
+		"ctrl+pgdn":       "select.pagedown",
+		"ctrl+shift+home": "select.top",
+		"ctrl+shift+end":  "select.bottom",
+		"alt+up":          "scroll.up",
+		"alt+down":        "scroll.down",
+		"alt+pgup":        "scroll.pageup",
+		"alt+pgdn":        "scroll.pagedown",
+		"alt+m":           "mouse.toggle",
+		"ctrl+z":          "undo",
+		"shift+insert":    "clipboard.primary",
+		"alt+r":           "register.select",
+		"alt+V":           "clipboard.history",
+		"alt+q":           "macro.record",
+		"ctrl+]":          "bracket.match",
+		"alt+@":           "macro.play",
+		"ctrl+y":          "redo",
+		"enter":           "newline",
+		"backspace":       "delete.back",
+		"delete":          "delete.forward",
+		"tab":             "insert.tab",
+		"backtab":         "indent.less",
+		"ctrl+d":          "cursor.addnext",
+		"ctrl+alt+up":     "cursor.above",
+		"ctrl+alt+down":   "cursor.below",
+		"alt+I":           "cursor.splitlines",
+		"ctrl+b":          "selection.block",
+		"alt+shift+up":    "select.blockup",
+		"alt+shift+down":  "select.blockdown",
+		"alt+shift+left":  "select.blockleft",
+		"alt+shift+right": "select.blockright",
+	}
+}
+
+// viKeymap is the default bindings plus the vi keys that are not runes.
+func viKeymap() map[string]string {
+	keymap := defaultKeymap()
+	keymap["ctrl+r"] = "redo"
+	return keymap
+}
+
+var editorActions map[string]func(e *Editor)
+
+func init() {
+	editorActions = map[string]func(e *Editor){
+		"cancel":            (*Editor).Cancel,
+		"pane.next":         (*Editor).NextPane,
+		"clipboard.copy":    (*Editor).Copy,
+		"clipboard.paste":   (*Editor).Paste,
+		"clipboard.cut":     (*Editor).Cut,
+		"quit":              (*Editor).Quit,
+		"save":              (*Editor).Save,
+		"command":           (*Editor).OpenCommandBar,
+		"search":            (*Editor).OpenSearch,
+		"search.next":       (*Editor).SearchNext,
+		"search.prev":       (*Editor).SearchPrev,
+		"move.up":           func(e *Editor) { e.MoveUp(false) },
+		"move.down":         func(e *Editor) { e.MoveDown(false) },
+		"move.left":         func(e *Editor) { e.MoveLeft(false, false) },
+		"move.right":        func(e *Editor) { e.MoveRight(false, false) },
+		"move.wordleft":     func(e *Editor) { e.MoveLeft(false, true) },
+		"move.wordright":    func(e *Editor) { e.MoveRight(false, true) },
+		"select.up":         func(e *Editor) { e.MoveUp(true) },
+		"select.down":       func(e *Editor) { e.MoveDown(true) },
+		"select.left":       func(e *Editor) { e.MoveLeft(true, false) },
+		"select.right":      func(e *Editor) { e.MoveRight(true, false) },
+		"select.wordleft":   func(e *Editor) { e.MoveLeft(true, true) },
+		"select.wordright":  func(e *Editor) { e.MoveRight(true, true) },
+		"newline":           (*Editor).InsertNewline,
+		"delete.back":       (*Editor).DeleteBack,
+		"delete.forward":    (*Editor).DeleteForward,
+		"insert.tab":        (*Editor).InsertTab,
+		"indent.more":       (*Editor).IndentLines,
+		"indent.less":       (*Editor).DedentLines,
+		"move.linestart":    func(e *Editor) { e.MoveLineStart(false) },
+		"move.lineend":      func(e *Editor) { e.MoveLineEnd(false) },
+		"move.home":         func(e *Editor) { e.MoveHome(false) },
+		"move.end":          func(e *Editor) { e.MoveLineEnd(false) },
+		"move.pageup":       func(e *Editor) { e.MovePage(false, -1) },
+		"move.pagedown":     func(e *Editor) { e.MovePage(false, 1) },
+		"move.top":          func(e *Editor) { e.MoveDocument(false, false) },
+		"move.bottom":       func(e *Editor) { e.MoveDocument(false, true) },
+		"select.home":       func(e *Editor) { e.MoveHome(true) },
+		"select.end":        func(e *Editor) { e.MoveLineEnd(true) },
+		"select.pageup":     func(e *Editor) { e.MovePage(true, -1) },
+		"select.pagedown":   func(e *Editor) { e.MovePage(true, 1) },
+		"select.top":        func(e *Editor) { e.MoveDocument(true, false) },
+		"select.bottom":     func(e *Editor) { e.MoveDocument(true, true) },
+		"scroll.up":         func(e *Editor) { e.ScrollView(-1) },
+		"scroll.down":       func(e *Editor) { e.ScrollView(1) },
+		"scroll.pageup":     func(e *Editor) { e.ScrollView(-max(1, e.CurrentPane().Height-1)) },
+		"scroll.pagedown":   func(e *Editor) { e.ScrollView(max(1, e.CurrentPane().Height-1)) },
+		"kill.line":         (*Editor).KillLine,
+		"kill.region":       (*Editor).KillRegion,
+		"kill.copy":         (*Editor).KillCopy,
+		"yank":              (*Editor).Yank,
+		"yank.pop":          (*Editor).YankPop,
+		"mark.set":          (*Editor).SetMark,
+		"file.open":         (*Editor).PromptOpenFile,
+		"pane.hsplit":       func(e *Editor) { e.runCommand("hsplit") },
+		"pane.vsplit":       func(e *Editor) { e.runCommand("vsplit") },
+		"pane.close":        func(e *Editor) { e.runCommand("close") },
+		"cursor.addnext":    (*Editor).AddCursorAtNextMatch,
+		"cursor.above":      (*Editor).AddCursorAbove,
+		"cursor.below":      (*Editor).AddCursorBelow,
+		"cursor.splitlines": (*Editor).SplitSelectionIntoLines,
+		"selection.block":   (*Editor).ToggleBlockSelection,
+		"mouse.toggle":      (*Editor).ToggleMouse,
+		"undo":              (*Editor).Undo,
+		"clipboard.primary": (*Editor).PastePrimary,
+		"clipboard.history": (*Editor).ShowClipboardHistory,
+		"register.select":   (*Editor).SelectRegister,
+		"register.copy":     (*Editor).CopyToRegister,
+		"register.paste":    (*Editor).PasteFromRegister,
+		"macro.record":      (*Editor).ToggleMacroRecording,
+		"bracket.match":     (*Editor).JumpToBracket,
+		"macro.play":        (*Editor).PlayMacroPrompt,
+		"macro.replay":      (*Editor).ReplayMacro,
+		"redo":              (*Editor).Redo,
+		"select.blockup":    func(e *Editor) { e.SelectBlock(-1, 0) },
+		"select.blockdown":  func(e *Editor) { e.SelectBlock(1, 0) },
+		"select.blockleft":  func(e *Editor) { e.SelectBlock(0, -1) },
+		"select.blockright": func(e *Editor) { e.SelectBlock(0, 1) },
+	}
+}
+
+func actionNames() []string {
+	var names []string
+	for name := range editorActions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// bindings lists the keymap as "keys=action", sorted by keys.
+func (e *Editor) bindings() []string {
+	var bindings []string
+	for keys, action := range e.Keymap {
+		bindings = append(bindings, keys+"="+action)
+	}
+	sort.Strings(bindings)
+	return bindings
+}
+
+// loadKeymap starts from the bindings of the key profile and applies the
+// ones from the config file. An action of "none" removes a binding.
+func (e *Editor) loadKeymap() error {
+	e.Keymap = e.profileKeymap()
+	var errs []string
+	for keys, action := range e.Config.Keymap {
+		if err := e.Map(keys, action); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("keymap: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Map binds keys to action, or unbinds them if action is "none".
+func (e *Editor) Map(keys, action string) error {
+	seq, err := parseKeys(keys)
+	if err != nil {
+		return err
+	}
+	if e.Keymap == nil {
+		e.Keymap = e.profileKeymap()
+	}
+	if action == "none" {
+		delete(e.Keymap, seq)
+		return nil
+	}
+	if _, ok := editorActions[action]; !ok {
+		return fmt.Errorf("unknown action %q", action)
+	}
+	e.Keymap[seq] = action
+	return nil
+}
+
+// HandleMappedKey runs the action bound to ev, reporting whether ev was
+// used. A chord that starts a longer binding is held until the sequence is
+// complete or turns out not to be bound.
+func (e *Editor) HandleMappedKey(ev *tcell.EventKey) bool {
+	if e.Keymap == nil {
+		e.Keymap = e.profileKeymap()
+	}
+	chord := chordName(ev)
+	seq := chord
+	if e.pendingKeys != "" {
+		seq = e.pendingKeys + " " + chord
+	}
+
+	action, ok := e.Keymap[seq]
+	if !ok && e.pendingKeys == "" && ev.Modifiers()&tcell.ModShift != 0 {
+		// Shift+arrow and friends act like the unshifted key unless bound
+		action, ok = e.Keymap[strings.Replace(chord, "shift+", "", 1)]
+	}
+	if ok {
+		if e.pendingKeys != "" {
+			e.pendingKeys = ""
+			e.StatusMsg = ""
+		}
+		e.prevAction, e.lastAction = e.lastAction, action
+		e.runAction(action)
+		return true
+	}
+
+	for bound := range e.Keymap {
+		if strings.HasPrefix(bound, seq+" ") {
+			e.pendingKeys = seq
+			e.StatusMsg = seq + " ..."
+			return true
+		}
+	}
+	if e.pendingKeys != "" {
+		e.pendingKeys = ""
+		e.StatusMsg = fmt.Sprintf("%s is not mapped", seq)
+		return true
+	}
+	return false
+}
+
+// completeAction completes the action name that ends a map command.
+func (e *Editor) completeAction(args []string) {
+	if len(args) < 2 || strings.HasSuffix(e.Command, " ") {
+		return
+	}
+	prefix := args[len(args)-1]
+	var matches []string
+	for _, name := range actionNames() {
+		if strings.HasPrefix(name, prefix) {
+			matches = append(matches, name)
+		}
+	}
+	if len(matches) == 0 {
+		return
+	}
+	common := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	e.Command = "map " + strings.Join(args[:len(args)-1], " ") + " " + common
+	if len(matches) > 1 {
+		e.CommandHint = strings.Join(matches, " ")
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+const (
+	largeFileThreshold = 64 << 20
+	largeIndexStride   = 64       // lines between recorded offsets
+	largeLineLimit     = 64 << 10 // longer lines are cut off for display
+	largeBlockCache    = 32
+	largeSearchLimit   = 100000
+	largeEventInterval = 100 * time.Millisecond
+)
+
+// largeFile backs a Buffer whose file is too big to hold in memory. Line
+// offsets are indexed in the background and lines are read on demand, a
+// block of largeIndexStride lines at a time.
+type largeFile struct {
+	file *os.File
+	size int64
+	stop chan struct{}
+
+	mu      sync.Mutex
+	index   []int64 // offset of every largeIndexStride-th line
+	lines   int     // newlines seen so far
+	scanned int64
+	done    bool
+	err     error
+
+	// Only touched on the UI goroutine
+	blocks      map[int][]string
+	blockOrder  []int
+	PendingGoto int
+	searchStop  chan struct{}
+	searchPos   int64
+}
+
+// largeFileEvent reports indexing progress.
+type largeFileEvent struct {
+	tcell.EventTime
+	Buffer *Buffer
+}
+
+// largeSearchEvent delivers a batch of matches from a streaming search.
+type largeSearchEvent struct {
+	tcell.EventTime
+	Buffer  *Buffer
+	ID      int
+	Matches []SearchMatch
+	Pos     int64
+	Done    bool
+}
+
+func (b *Buffer) loadLarge(filename string, screen tcell.Screen) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	lf := &largeFile{
+		file:        f,
+		size:        fi.Size(),
+		stop:        make(chan struct{}),
+		index:       []int64{0},
+		blocks:      make(map[int][]string),
+		PendingGoto: -1,
+	}
+	b.Filename = filename
+	b.Large = lf
+	b.Lines = []string{""}
+	b.Lexer = nil
+	b.TokenCache = nil
+	b.ReadOnly = true
+	go lf.buildIndex(screen, b)
+	return nil
+}
+
+func (lf *largeFile) Close() {
+	close(lf.stop)
+	lf.stopSearch()
+	lf.file.Close()
+}
+
+func (lf *largeFile) buildIndex(screen tcell.Screen, buf *Buffer) {
+	chunk := make([]byte, 1<<20)
+	var off int64
+	lines := 0
+	var index []int64
+	lastEvent := time.Now()
+
+	for {
+		select {
+		case <-lf.stop:
+			return
+		default:
+		}
+
+		n, err := lf.file.ReadAt(chunk, off)
+		data := chunk[:n]
+		for pos := 0; ; {
+			i := bytes.IndexByte(data[pos:], '\n')
+			if i < 0 {
+				break
+			}
+			pos += i + 1
+			lines++
+			if lines%largeIndexStride == 0 {
+				index = append(index, off+int64(pos))
+			}
+		}
+		off += int64(n)
+
+		lf.mu.Lock()
+		lf.index = append(lf.index, index...)
+		lf.lines = lines
+		lf.scanned = off
+		if err != nil {
+			lf.done = true
+			if err != io.EOF {
+				lf.err = err
+			}
+		}
+		done := lf.done
+		lf.mu.Unlock()
+		index = index[:0]
+
+		if done || time.Since(lastEvent) >= largeEventInterval {
+			ev := &largeFileEvent{Buffer: buf}
+			ev.SetEventNow()
+			if done {
+				// The final event must not be dropped on a full queue
+				screen.PostEventWait(ev)
+			} else {
+				screen.PostEvent(ev)
+			}
+			lastEvent = time.Now()
+		}
+		if done {
+			return
+		}
+	}
+}
+
+// LineCount returns the number of lines indexed so far. Like strings.Split,
+// a file always has one more line than it has newlines.
+func (lf *largeFile) LineCount() int {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.done {
+		return lf.lines + 1
+	}
+	return max(1, lf.lines)
+}
+
+func (lf *largeFile) Line(i int) string {
+	block := lf.block(i / largeIndexStride)
+	if i%largeIndexStride < len(block) {
+		return block[i%largeIndexStride]
+	}
+	return ""
+}
+
+func (lf *largeFile) block(k int) []string {
+	if block, ok := lf.blocks[k]; ok {
+		return block
+	}
+
+	lf.mu.Lock()
+	if k >= len(lf.index) {
+		lf.mu.Unlock()
+		return nil
+	}
+	start := lf.index[k]
+	lf.mu.Unlock()
+
+	r := bufio.NewReader(io.NewSectionReader(lf.file, start, lf.size-start))
+	block := make([]string, 0, largeIndexStride)
+	for len(block) < largeIndexStride {
+		// At EOF the remainder is the last line, empty after a trailing newline
+		line, err := readLimitedLine(r)
+		block = append(block, line)
+		if err != nil {
+			break
+		}
+	}
+
+	if len(lf.blockOrder) >= largeBlockCache {
+		delete(lf.blocks, lf.blockOrder[0])
+		lf.blockOrder = lf.blockOrder[1:]
+	}
+	lf.blocks[k] = block
+	lf.blockOrder = append(lf.blockOrder, k)
+	return block
+}
+
+// readLimitedLine reads one line, dropping anything past largeLineLimit.
+func readLimitedLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		part, err := r.ReadSlice('\n')
+		if len(line) < largeLineLimit {
+			line = append(line, part[:min(len(part), largeLineLimit-len(line))]...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		s := strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r")
+		return s, err
+	}
+}
+
+func (lf *largeFile) Progress() string {
+	lf.mu.Lock()
+	done, scanned, err := lf.done, lf.scanned, lf.err
+	lf.mu.Unlock()
+
+	var parts []string
+	if err != nil {
+		parts = append(parts, fmt.Sprintf("index error: %v", err))
+	} else if !done && lf.size > 0 {
+		parts = append(parts, fmt.Sprintf("indexing %d%%", scanned*100/lf.size))
+	}
+	if lf.searchStop != nil && lf.size > 0 {
+		parts = append(parts, fmt.Sprintf("searching %d%%", lf.searchPos*100/lf.size))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (e *Editor) HandleLargeFileEvent(ev *largeFileEvent) {
+	buf := ev.Buffer
+	lf := buf.Large
+	if lf == nil || lf.PendingGoto < 0 {
+		return
+	}
+	if lf.PendingGoto < lf.LineCount() {
+		for _, pane := range e.Panes {
+			if pane.Buffer == buf {
+				e.gotoLine(pane, lf.PendingGoto)
+			}
+		}
+		lf.PendingGoto = -1
+	}
+}
+
+func (lf *largeFile) stopSearch() {
+	if lf.searchStop != nil {
+		close(lf.searchStop)
+		lf.searchStop = nil
+	}
+}
+
+// StartLargeSearch streams the file from disk instead of scanning Lines.
+// Matches arrive in batches as largeSearchEvents tagged with id, so batches
+// from an abandoned search can be told apart.
+func (e *Editor) StartLargeSearch(buf *Buffer, query string, id int) {
+	lf := buf.Large
+	lf.stopSearch()
+	stop := make(chan struct{})
+	lf.searchStop = stop
+	lf.searchPos = 0
+	screen := e.Screen
+
+	go func() {
+		r := bufio.NewReaderSize(io.NewSectionReader(lf.file, 0, lf.size), 1<<20)
+		var batch []SearchMatch
+		var pos int64
+		found := 0
+		lastEvent := time.Now()
+		post := func(done bool) {
+			ev := &largeSearchEvent{Buffer: buf, ID: id, Matches: batch, Pos: pos, Done: done}
+			ev.SetEventNow()
+			if done {
+				screen.PostEventWait(ev)
+			} else {
+				screen.PostEvent(ev)
+			}
+			batch = nil
+			lastEvent = time.Now()
+		}
+
+		for lineIdx := 0; ; lineIdx++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			raw, err := r.ReadString('\n')
+			pos += int64(len(raw))
+			line := strings.TrimSuffix(strings.TrimSuffix(raw, "\n"), "\r")
+			for start := 0; found < largeSearchLimit; {
+				idx := strings.Index(line[start:], query)
+				if idx == -1 {
+					break
+				}
+				batch = append(batch, SearchMatch{Line: lineIdx, Col: start + idx, Len: len(query)})
+				found++
+				start += idx + 1
+			}
+			if err != nil || found >= largeSearchLimit {
+				post(true)
+				return
+			}
+			if time.Since(lastEvent) >= largeEventInterval {
+				post(false)
+			}
+		}
+	}()
+}
+
+func (e *Editor) HandleLargeSearchEvent(ev *largeSearchEvent) {
+	lf := ev.Buffer.Large
+	if lf == nil || ev.ID != e.searchID {
+		return
+	}
+	lf.searchPos = ev.Pos
+	first := len(e.SearchMatches) == 0
+	e.SearchMatches = append(e.SearchMatches, ev.Matches...)
+	if first && len(e.SearchMatches) > 0 && e.CurrentBuffer() == ev.Buffer {
+		e.SearchIndex = 0
+		e.JumpToSearchMatch()
+	}
+	if ev.Done {
+		lf.searchStop = nil
+		switch {
+		case len(e.SearchMatches) >= largeSearchLimit:
+			e.StatusMsg = fmt.Sprintf("Found %d+ matches (stopped)", len(e.SearchMatches))
+		case len(e.SearchMatches) > 0:
+			e.StatusMsg = fmt.Sprintf("Found %d matches", len(e.SearchMatches))
+		default:
+			e.StatusMsg = "No matches found"
+		}
+	}
+}
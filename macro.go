@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+const macroDepth = 20
+
+// macroState records keys into a register and plays them back.
+type macroState struct {
+	recording rune // register being recorded into, 0 when not recording
+	started   bool // recording started with the key being handled
+	keys      []*tcell.EventKey
+	mark      int  // where the key sequence being typed started in keys
+	last      rune // last macro played, for @@
+	depth     int  // macros playing inside each other
+	played    bool // the key being handled played a macro
+}
+
+// recordKey adds a key handled while recording. Keys that start a binding
+// are kept until it is complete, so stopping leaves out all of its keys.
+func (e *Editor) recordKey(ev *tcell.EventKey, before bool) {
+	m := &e.macro
+	if m.recording == 0 || m.depth > 0 {
+		return
+	}
+	if before {
+		if e.pendingKeys == "" && len(e.vi.pending) == 0 && e.registerAction == nil {
+			m.mark = len(m.keys)
+		}
+		return
+	}
+	if m.started {
+		m.started = false
+		return
+	}
+	m.keys = append(m.keys, ev)
+}
+
+// ToggleMacroRecording asks for a register to record keys into, or stops
+// recording.
+func (e *Editor) ToggleMacroRecording() {
+	if e.macro.recording != 0 {
+		e.StopMacroRecording()
+		return
+	}
+	e.askRegister("Record macro into register: ", e.StartMacroRecording)
+}
+
+func (e *Editor) StartMacroRecording(r rune) {
+	e.macro.recording = r
+	e.macro.started = true
+	e.macro.keys = nil
+	e.StatusMsg = fmt.Sprintf("Recording @%c", unicode.ToLower(r))
+}
+
+func (e *Editor) StopMacroRecording() {
+	m := &e.macro
+	if m.recording == 0 {
+		return
+	}
+	keys := m.keys[:min(m.mark, len(m.keys))]
+	r := unicode.ToLower(m.recording)
+	if e.macros == nil {
+		e.macros = map[rune][]*tcell.EventKey{}
+	}
+	if unicode.IsUpper(m.recording) {
+		keys = append(e.macros[r], keys...)
+	}
+	e.macros[r] = keys
+	m.recording, m.keys = 0, nil
+	m.last = r
+	e.StatusMsg = fmt.Sprintf("Recorded @%c: %d keys", r, len(keys))
+}
+
+// PlayMacro plays the macro in register r count times. It stops at the
+// first motion or search that fails, like running off the end of the
+// buffer. All of it is undone in one step.
+func (e *Editor) PlayMacro(r rune, count int) {
+	m := &e.macro
+	if r == '@' {
+		r = m.last
+	}
+	r = unicode.ToLower(r)
+	keys := e.macros[r]
+	if len(keys) == 0 {
+		e.StatusMsg = fmt.Sprintf("No macro in register %c", max(r, '@'))
+		return
+	}
+	if m.depth >= macroDepth {
+		e.motionFailed = true
+		e.StatusMsg = "Macros nested too deeply"
+		return
+	}
+	m.last = r
+	m.played = true
+	m.depth++
+	defer func() { m.depth-- }()
+	if m.depth == 1 {
+		e.StatusMsg = ""
+	}
+	for i := 0; i < max(1, count); i++ {
+		for _, ev := range keys {
+			e.motionFailed = false
+			e.HandleKey(ev)
+			if e.motionFailed {
+				if m.depth == 1 && e.StatusMsg != "" {
+					e.StatusMsg = fmt.Sprintf("Macro @%c stopped: %s", r, e.StatusMsg)
+				} else if m.depth == 1 {
+					e.StatusMsg = fmt.Sprintf("Macro @%c stopped at a failed motion", r)
+				}
+				return
+			}
+		}
+	}
+}
+
+// PlayMacroPrompt asks for the register of the macro to play.
+func (e *Editor) PlayMacroPrompt() {
+	e.askRegister("Play macro from register: ", func(r rune) { e.PlayMacro(r, 1) })
+}
+
+// ReplayMacro plays the last macro again.
+func (e *Editor) ReplayMacro() {
+	e.PlayMacro('@', 1)
+}
+
+// macroText writes keys the way bindings are written, so macros can go in
+// the config file.
+func macroText(keys []*tcell.EventKey) string {
+	chords := make([]string, len(keys))
+	for i, ev := range keys {
+		chords[i] = chordName(ev)
+	}
+	return strings.Join(chords, " ")
+}
+
+// parseMacro turns a macro from the config file back into keys.
+func parseMacro(text string) ([]*tcell.EventKey, error) {
+	var keys []*tcell.EventKey
+	for _, chord := range strings.Fields(text) {
+		chord, err := parseKeys(chord)
+		if err != nil {
+			return nil, err
+		}
+		ev, err := chordEvent(chord)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, ev)
+	}
+	return keys, nil
+}
+
+// chordEvent makes the key event chordName would name chord.
+func chordEvent(chord string) (*tcell.EventKey, error) {
+	var mods tcell.ModMask
+	name := chord
+	for {
+		if rest, ok := strings.CutPrefix(name, "ctrl+"); ok && rest != "" {
+			mods |= tcell.ModCtrl
+			name = rest
+		} else if rest, ok := strings.CutPrefix(name, "alt+"); ok && rest != "" {
+			mods |= tcell.ModAlt
+			name = rest
+		} else if rest, ok := strings.CutPrefix(name, "shift+"); ok && rest != "" {
+			mods |= tcell.ModShift
+			name = rest
+		} else {
+			break
+		}
+	}
+	runes := []rune(name)
+	switch {
+	case name == "space" && mods&tcell.ModCtrl != 0:
+		return tcell.NewEventKey(tcell.KeyCtrlSpace, 0, mods), nil
+	case name == "space":
+		return tcell.NewEventKey(tcell.KeyRune, ' ', mods), nil
+	case name == "backspace":
+		return tcell.NewEventKey(tcell.KeyBackspace2, 0, mods), nil
+	case len(runes) == 1 && mods&tcell.ModCtrl != 0 && runes[0] >= 'a' && runes[0] <= 'z':
+		key := tcell.KeyCtrlA + tcell.Key(runes[0]-'a')
+		return tcell.NewEventKey(key, rune(key), mods), nil
+	case len(runes) == 1:
+		return tcell.NewEventKey(tcell.KeyRune, runes[0], mods), nil
+	}
+	for key, keyName := range keyNames {
+		if keyName == name {
+			return tcell.NewEventKey(key, 0, mods), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key %q", chord)
+}
+
+// loadMacros reads the [macros] table of the config file, register names
+// to keys written like bindings.
+func (e *Editor) loadMacros() error {
+	var errs []string
+	for name, text := range e.Config.Macros {
+		r := []rune(name)
+		if len(r) != 1 || !isRegister(r[0]) {
+			errs = append(errs, fmt.Sprintf("macro %q: register must be a-z", name))
+			continue
+		}
+		keys, err := parseMacro(text)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("macro %s: %v", name, err))
+			continue
+		}
+		if e.macros == nil {
+			e.macros = map[rune][]*tcell.EventKey{}
+		}
+		e.macros[r[0]] = keys
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// ShowMacros lists the macros in a picker, choosing one plays it.
+func (e *Editor) ShowMacros() {
+	var names []rune
+	for r, keys := range e.macros {
+		if len(keys) > 0 {
+			names = append(names, r)
+		}
+	}
+	if len(names) == 0 {
+		e.StatusMsg = "No macros recorded"
+		return
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	var items []pickerItem
+	for _, r := range names {
+		items = append(items, pickerItem{label: "@" + string(r), text: macroText(e.macros[r])})
+	}
+	e.openPicker("Macros", items, func(item pickerItem) {
+		e.PlayMacro([]rune(item.label)[1], 1)
+	})
+	e.StatusMsg = "Up/Down to choose, Enter to play, Esc to cancel"
+}
+
+// macroCommand runs "macros", and "play <register> [count]".
+func (e *Editor) macroCommand(cmd string, args []string) {
+	if cmd == "macros" {
+		e.ShowMacros()
+		return
+	}
+	if len(args) < 1 || len([]rune(args[0])) != 1 {
+		e.StatusMsg = "Usage: play <register> [count]"
+		return
+	}
+	count := 1
+	if len(args) > 1 {
+		if _, err := fmt.Sscan(args[1], &count); err != nil || count < 1 {
+			e.StatusMsg = "Invalid count"
+			return
+		}
+	}
+	// The keys are for the buffer, not the command bar
+	e.CommandMode = false
+	e.Command = ""
+	e.PlayMacro([]rune(args[0])[0], count)
+}
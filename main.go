@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -11,7 +12,6 @@ import (
 	"github.com/alecthomas/chroma/v2"
 	"github.com/alecthomas/chroma/v2/lexers"
 	"github.com/alecthomas/chroma/v2/styles"
-	"github.com/atotto/clipboard"
 	"github.com/gdamore/tcell/v2"
 )
 
@@ -19,12 +19,15 @@ type Selection struct {
 	StartLine, StartCol int
 	EndLine, EndCol     int
 	Active              bool
+	Mark                bool // set with mark.set: the selection follows the cursor
+	Block               bool // a rectangle; StartCol and EndCol are visual columns
 }
 
 type TokenInfo struct {
 	Col   int
 	Len   int
 	Style tcell.Style
+	Type  chroma.TokenType // what the lexer made of it, e.g. to skip brackets in strings
 }
 
 type Buffer struct {
@@ -35,12 +38,26 @@ type Buffer struct {
 	OffsetX        int
 	OffsetY        int
 	Selection      Selection
+	Cursors        []Cursor // more cursors besides CursorX/CursorY
 	Lexer          chroma.Lexer
 	Style          *chroma.Style
 	TokenCache     [][]TokenInfo
-	Dirty          bool
+	Checkpoints    []lexCheckpoint
 	DirtyLineStart int
-	DirtyLineEnd   int
+	Version        int // bumped whenever Lines or TokenCache are invalidated
+	highlighting   bool
+	Modified       bool
+	ReadOnly       bool
+	Pager          bool // less-style keys, for text piped in
+	Follow         *follower
+	ANSI           *ansiView
+	Hex            *hexView
+	Large          *largeFile
+	TabWidth       int   // 0 for the tabwidth setting
+	ShiftWidth     int   // 0 for the shiftwidth setting
+	ExpandTab      *bool // nil for the expandtab setting
+	Format         fileFormat
+	history        undoHistory
 }
 
 type SplitType int
@@ -57,6 +74,7 @@ type Pane struct {
 	Width      int
 	Height     int
 	GutterWidth int
+	frame      *paneFrame
 }
 
 type SearchMatch struct {
@@ -72,33 +90,66 @@ type Editor struct {
 	SplitType     SplitType
 	CommandMode   bool
 	Command       string
+	CommandHint   string // completion candidates shown after the command
 	StatusMsg     string
 	SearchMode    bool
 	SearchQuery   string
 	SearchMatches []SearchMatch
 	SearchIndex   int
+	Keymap        map[string]string // key sequence to action name
+	pendingKeys   string
+	vi            viState
+	killRing      killRing
+	lastAction    string // the action the last key ran, if it ran one
+	prevAction    string
+	Theme         *Theme
+	Config        Config
+	clipboard     clipboardProvider // nil keeps copies inside accela
+	clipboardText string
+	primaryText   string
+	clipRing      []string      // recent cuts and copies, newest last
+	registers     map[rune]string
+	register      rune          // register the next copy, cut or paste uses instead of the clipboard
+	registerAction func(r rune) // waiting for a register name to do this with
+	picker        *picker
+	macro         macroState
+	macros        map[rune][]*tcell.EventKey
+	motionFailed  bool // a motion or search went nowhere, which stops a macro
+	commandHistory []string
+	historyIndex  int
+	commandDraft  string // what was typed before going through the history
+	clipboardBlock string // the last block copied, so pasting it puts it back as a block
+	mouse         mouseState
+	pasting       bool // between the start and end of a bracketed paste
+	pasted        strings.Builder
+	splitSize     int // rows or columns of the first split, 0 for half
+	searchID      int
 }
 
 func NewBuffer() *Buffer {
 	return &Buffer{
 		Lines:          []string{""},
-		Style:          styles.Get("monokai"),
+		Style:          styles.Get(defaultColorscheme),
 		DirtyLineStart: -1,
-		DirtyLineEnd:   -1,
 	}
 }
 
 func (b *Buffer) SetupHighlighting() {
 	if b.Filename == "" {
-		b.Lexer = lexers.Fallback
-		return
+		// Without a filename the only hint is the content itself
+		b.Lexer = lexers.Analyse(strings.Join(b.Lines, "\n"))
+	} else {
+		b.Lexer = lexers.Match(b.Filename)
 	}
-	b.Lexer = lexers.Match(b.Filename)
 	if b.Lexer == nil {
 		b.Lexer = lexers.Fallback
 	}
 	b.Lexer = chroma.Coalesce(b.Lexer)
-	b.UpdateTokenCache()
+	// Tokens are filled in lazily as lines are drawn
+	b.TokenCache = make([][]TokenInfo, len(b.Lines))
+	b.Checkpoints = nil
+	b.DirtyLineStart = 0
+	b.Version++
 }
 
 func chromaToTcell(c chroma.Colour) tcell.Color {
@@ -108,104 +159,102 @@ func chromaToTcell(c chroma.Colour) tcell.Color {
 	return tcell.NewRGBColor(int32(c.Red()), int32(c.Green()), int32(c.Blue()))
 }
 
-func (b *Buffer) UpdateTokenCache() {
-	b.UpdateTokenCacheRange(0, len(b.Lines)-1)
-}
-
-func (b *Buffer) UpdateTokenCacheRange(startLine, endLine int) {
-	if b.Lexer == nil || b.Style == nil {
-		return
+func (b *Buffer) GetStyleAt(line, col int) tcell.Style {
+	if line >= len(b.TokenCache) {
+		return tcell.StyleDefault
 	}
-
-	// Expand range to handle multi-line tokens (strings, comments)
-	// Look back up to 50 lines for safety
-	contextLines := 50
-	startLine = max(0, startLine-contextLines)
-	endLine = min(len(b.Lines)-1, endLine+contextLines)
-
-	// Ensure TokenCache has enough capacity
-	if len(b.TokenCache) != len(b.Lines) {
-		newCache := make([][]TokenInfo, len(b.Lines))
-		copy(newCache, b.TokenCache)
-		b.TokenCache = newCache
+	for _, token := range b.TokenCache[line] {
+		if col >= token.Col && col < token.Col+token.Len {
+			return token.Style
+		}
 	}
+	return tcell.StyleDefault
+}
 
-	// Clear tokens for lines we're about to re-tokenize
-	for i := startLine; i <= endLine && i < len(b.TokenCache); i++ {
-		b.TokenCache[i] = []TokenInfo{}
+func (b *Buffer) LineCount() int {
+	if b.Large != nil {
+		return b.Large.LineCount()
 	}
+	return len(b.Lines)
+}
 
-	// Tokenize only the relevant portion with context
-	content := strings.Join(b.Lines[startLine:endLine+1], "\n")
-	iterator, err := b.Lexer.Tokenise(nil, content)
-	if err != nil {
-		return
+func (b *Buffer) Line(i int) string {
+	if b.Large != nil {
+		return b.Large.Line(i)
 	}
+	return b.Lines[i]
+}
 
-	lineNum := startLine
-	col := 0
-
-	for _, token := range iterator.Tokens() {
-		entry := b.Style.Get(token.Type)
-		fg := chromaToTcell(entry.Colour)
-		style := tcell.StyleDefault.Foreground(fg)
-		if entry.Bold == chroma.Yes {
-			style = style.Bold(true)
-		}
-		if entry.Italic == chroma.Yes {
-			style = style.Italic(true)
-		}
-
-		tokenLines := strings.Split(token.Value, "\n")
-		for i, part := range tokenLines {
-			if i > 0 {
-				lineNum++
-				col = 0
-			}
-			if lineNum < len(b.TokenCache) && len(part) > 0 {
-				b.TokenCache[lineNum] = append(b.TokenCache[lineNum], TokenInfo{
-					Col:   col,
-					Len:   len(part),
-					Style: style,
-				})
-			}
-			col += len(part)
-		}
+// OpenFile loads filename into buf, switching to large-file mode above
+// largeFileThreshold.
+func (e *Editor) OpenFile(buf *Buffer, filename string) error {
+	buf.closeLarge()
+	if buf.Pager {
+		// The piped text goes, and the pager with it
+		buf.Pager, buf.ReadOnly, buf.ANSI = false, false, nil
 	}
+	if fi, err := os.Stat(filename); err == nil && fi.Mode().IsRegular() && fi.Size() > largeFileThreshold {
+		buf.Hex = nil
+		return buf.loadLarge(filename, e.Screen)
+	}
+	return buf.LoadFile(filename)
 }
 
-func (b *Buffer) GetStyleAt(line, col int) tcell.Style {
-	if line >= len(b.TokenCache) {
-		return tcell.StyleDefault
-	}
-	for _, token := range b.TokenCache[line] {
-		if col >= token.Col && col < token.Col+token.Len {
-			return token.Style
-		}
+func (b *Buffer) closeLarge() {
+	if b.Large != nil {
+		b.Large.Close()
+		b.Large = nil
+		b.ReadOnly = false
 	}
-	return tcell.StyleDefault
 }
 
 func (b *Buffer) LoadFile(filename string) error {
+	b.Hex = nil
+	b.Modified = false
+	b.history = undoHistory{}
 	data, err := os.ReadFile(filename)
-	if err != nil {
-		if os.IsNotExist(err) {
-			b.Filename = filename
-			b.Lines = []string{""}
-			b.SetupHighlighting()
-			return nil
-		}
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
+	props := editorConfig(filename)
 	b.Filename = filename
-	content := string(data)
+	b.TabWidth, b.ShiftWidth, b.ExpandTab = 0, 0, nil
+	b.Format = fileFormat{charset: props["charset"]}
+	if err != nil {
+		b.Lines = []string{""}
+		b.applyEditorConfig(props)
+		b.SetupHighlighting()
+		return nil
+	}
+	text, charset, ok := decodeText(data, props["charset"])
+	if !ok {
+		b.loadBinary(data)
+		return nil
+	}
+	b.Format.charset = charset
+	b.Format.lineEnding, text = detectLineEnding(text)
+	b.setContent(text)
+	b.detectIndent()
+	b.applyEditorConfig(props)
+	b.SetupHighlighting()
+	return nil
+}
+
+func (b *Buffer) loadBinary(data []byte) {
+	b.Hex = &hexView{Data: data}
+	b.Lines = []string{""}
+	b.Lexer = nil
+	b.TokenCache = nil
+}
+
+func (b *Buffer) setContent(content string) {
 	content = strings.ReplaceAll(content, "\r\n", "\n")
 	b.Lines = strings.Split(content, "\n")
 	if len(b.Lines) == 0 {
 		b.Lines = []string{""}
 	}
-	b.SetupHighlighting()
-	return nil
+	b.Cursors = nil
+	b.history = undoHistory{}
 }
 
 func (b *Buffer) SetFilename(filename string) {
@@ -217,22 +266,22 @@ func (b *Buffer) MarkDirty() {
 	b.MarkDirtyLines(b.CursorY, b.CursorY)
 }
 
+// MarkDirtyLines drops the tokens of lines start to end after an edit. When
+// the edit added or removed lines, they must sit right after start.
 func (b *Buffer) MarkDirtyLines(start, end int) {
-	b.Dirty = true
-	if b.DirtyLineStart < 0 || start < b.DirtyLineStart {
-		b.DirtyLineStart = start
+	b.Version++
+	b.Modified = true
+	if b.Lexer == nil || b.ANSI != nil {
+		return
 	}
-	if end > b.DirtyLineEnd {
-		b.DirtyLineEnd = end
+	if delta := len(b.Lines) - len(b.TokenCache); delta != 0 {
+		b.spliceHighlight(start, delta)
 	}
-}
-
-func (b *Buffer) RefreshIfDirty() {
-	if b.Dirty && b.DirtyLineStart >= 0 {
-		b.UpdateTokenCacheRange(b.DirtyLineStart, b.DirtyLineEnd)
-		b.Dirty = false
-		b.DirtyLineStart = -1
-		b.DirtyLineEnd = -1
+	for i := start; i <= end && i < len(b.TokenCache); i++ {
+		b.TokenCache[i] = nil
+	}
+	if b.DirtyLineStart < 0 || start < b.DirtyLineStart {
+		b.DirtyLineStart = start
 	}
 }
 
@@ -240,8 +289,29 @@ func (b *Buffer) SaveFile() error {
 	if b.Filename == "" {
 		return fmt.Errorf("no filename")
 	}
-	content := strings.Join(b.Lines, "\n")
-	return os.WriteFile(b.Filename, []byte(content), 0644)
+	if b.Hex != nil {
+		return os.WriteFile(b.Filename, b.Hex.Data, 0644)
+	}
+	if b.Large != nil {
+		return fmt.Errorf("large files are opened read-only")
+	}
+	if b.Format.trimTrailing && b.ANSI == nil {
+		b.trimTrailingSpace()
+	}
+	lines := b.Lines
+	if b.ANSI != nil {
+		lines = b.ANSI.Raw
+	}
+	content, err := b.Format.encode(lines)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(b.Filename, content, 0644); err != nil {
+		return err
+	}
+	b.Modified = false
+	b.history.markSaved()
+	return nil
 }
 
 func (b *Buffer) GetSelectedText() string {
@@ -257,7 +327,7 @@ func (b *Buffer) GetSelectedText() string {
 	}
 	
 	if startLine == endLine {
-		line := b.Lines[startLine]
+		line := b.Line(startLine)
 		if startCol > len(line) {
 			startCol = len(line)
 		}
@@ -269,7 +339,7 @@ func (b *Buffer) GetSelectedText() string {
 	
 	var result strings.Builder
 	for i := startLine; i <= endLine; i++ {
-		line := b.Lines[i]
+		line := b.Line(i)
 		if i == startLine {
 			if startCol < len(line) {
 				result.WriteString(line[startCol:])
@@ -319,7 +389,7 @@ func (b *Buffer) DeleteSelection() {
 	b.CursorX = startCol
 	b.CursorY = startLine
 	b.Selection.Active = false
-	b.MarkDirtyLines(startLine, len(b.Lines)-1)
+	b.MarkDirtyLines(startLine, startLine)
 }
 
 func isWordChar(ch rune) bool {
@@ -327,11 +397,11 @@ func isWordChar(ch rune) bool {
 }
 
 func (b *Buffer) MoveWordLeft() {
-	runes := []rune(b.Lines[b.CursorY])
+	runes := []rune(b.Line(b.CursorY))
 	if b.CursorX == 0 {
 		if b.CursorY > 0 {
 			b.CursorY--
-			b.CursorX = len([]rune(b.Lines[b.CursorY]))
+			b.CursorX = len([]rune(b.Line(b.CursorY)))
 		}
 		return
 	}
@@ -349,9 +419,9 @@ func (b *Buffer) MoveWordLeft() {
 }
 
 func (b *Buffer) MoveWordRight() {
-	runes := []rune(b.Lines[b.CursorY])
+	runes := []rune(b.Line(b.CursorY))
 	if b.CursorX >= len(runes) {
-		if b.CursorY < len(b.Lines)-1 {
+		if b.CursorY < b.LineCount()-1 {
 			b.CursorY++
 			b.CursorX = 0
 		}
@@ -368,13 +438,15 @@ func (b *Buffer) MoveWordRight() {
 }
 
 func NewEditor() (*Editor, error) {
-	screen, err := tcell.NewScreen()
+	screen, err := newScreen()
 	if err != nil {
 		return nil, err
 	}
 	if err := screen.Init(); err != nil {
 		return nil, err
 	}
+	screen.EnablePaste()
+	screen = newPaletteScreen(screen)
 	
 	w, h := screen.Size()
 	buf := NewBuffer()
@@ -391,6 +463,8 @@ func NewEditor() (*Editor, error) {
 		Panes:      []*Pane{pane},
 		ActivePane: 0,
 		SplitType:  SplitNone,
+		Theme:      defaultTheme(),
+		Config:     defaultConfig(),
 	}, nil
 }
 
@@ -413,7 +487,7 @@ func (e *Editor) UpdatePaneSizes() {
 		e.Panes[0].Height = editHeight
 	} else if len(e.Panes) == 2 {
 		if e.SplitType == SplitHorizontal {
-			halfH := editHeight / 2
+			halfH := e.splitAt(editHeight)
 			e.Panes[0].X = 0
 			e.Panes[0].Y = 0
 			e.Panes[0].Width = w
@@ -423,7 +497,7 @@ func (e *Editor) UpdatePaneSizes() {
 			e.Panes[1].Width = w
 			e.Panes[1].Height = editHeight - halfH
 		} else {
-			halfW := w / 2
+			halfW := e.splitAt(w)
 			e.Panes[0].X = 0
 			e.Panes[0].Y = 0
 			e.Panes[0].Width = halfW
@@ -437,52 +511,73 @@ func (e *Editor) UpdatePaneSizes() {
 }
 
 func (e *Editor) Draw() {
-	e.Screen.Clear()
+	// No Clear: panes and bars cover the whole screen and panes only
+	// repaint the cells that changed
 	e.UpdatePaneSizes()
 	
 	for i, pane := range e.Panes {
 		e.DrawPane(pane, i == e.ActivePane)
 	}
+	if e.picker != nil {
+		e.DrawPicker()
+	}
 	
 	e.DrawStatusBar()
 	e.DrawCommandBar()
+	e.Screen.SetCursorStyle(e.cursorStyle())
 	e.Screen.Show()
 }
 
 func (e *Editor) DrawPane(pane *Pane, active bool) {
 	buf := pane.Buffer
-	buf.RefreshIfDirty()
-	selStyle := tcell.StyleDefault.Background(tcell.ColorBlue).Foreground(tcell.ColorWhite)
-	searchStyle := tcell.StyleDefault.Background(tcell.ColorYellow).Foreground(tcell.ColorBlack)
-	gutterStyle := tcell.StyleDefault.Foreground(tcell.ColorDarkGray)
-	const tabWidth = 4
+	if buf.Hex != nil {
+		e.DrawHexPane(pane, active)
+		return
+	}
+	e.ScheduleHighlight(pane)
+	pane.beginFrame()
+	bgStyle := e.Theme.Background
+	gutterStyle := e.Theme.Gutter
+	_, cursorLineBg, _ := e.Theme.CursorLine.Decompose()
+	_, themeBg, _ := bgStyle.Decompose()
+	tabWidth := e.tabWidth(buf)
 
 	// Calculate gutter width based on total line count
-	lineCount := len(buf.Lines)
-	gutterWidth := len(fmt.Sprintf("%d", lineCount)) + 1 // +1 for spacing
-	if gutterWidth < 3 {
-		gutterWidth = 3
+	lineCount := buf.LineCount()
+	gutterWidth := 0
+	if e.Config.LineNumbers {
+		gutterWidth = len(fmt.Sprintf("%d", lineCount)) + 1 // +1 for spacing
+		if gutterWidth < 3 {
+			gutterWidth = 3
+		}
 	}
 	pane.GutterWidth = gutterWidth
 	textAreaWidth := pane.Width - gutterWidth
+	var bracketMarks []bracketMark
+	if active {
+		bracketMarks = buf.bracketMarks()
+	}
 
+	var styles []tcell.Style
 	for row := 0; row < pane.Height; row++ {
 		lineIdx := buf.OffsetY + row
-		if lineIdx >= len(buf.Lines) {
+		if lineIdx >= lineCount {
 			// Draw empty gutter and text area
 			for col := 0; col < pane.Width; col++ {
-				e.Screen.SetContent(pane.X+col, pane.Y+row, ' ', nil, tcell.StyleDefault)
+				e.setCell(pane, col, row, ' ', bgStyle)
 			}
 			continue
 		}
 
 		// Draw line number in gutter
-		lineNumStr := fmt.Sprintf("%*d ", gutterWidth-1, lineIdx+1)
-		for i, ch := range lineNumStr {
-			e.Screen.SetContent(pane.X+i, pane.Y+row, ch, nil, gutterStyle)
+		if gutterWidth > 0 {
+			lineNumStr := fmt.Sprintf("%*d ", gutterWidth-1, lineIdx+1)
+			for i, ch := range lineNumStr {
+				e.setCell(pane, i, row, ch, gutterStyle)
+			}
 		}
 
-		runes := []rune(buf.Lines[lineIdx])
+		runes := []rune(buf.Line(lineIdx))
 		screenCol := 0
 		charIdx := 0
 
@@ -497,42 +592,65 @@ func (e *Editor) DrawPane(pane *Pane, active bool) {
 			charIdx++
 		}
 
+		// Styles for everything that can show up in this row, worked out once
+		from := charIdx
+		if visualCol > buf.OffsetX {
+			from--
+		}
+		to := min(len(runes), charIdx+textAreaWidth)
+		styles = e.lineStyles(styles, buf, lineIdx, from, to)
+		for _, mark := range bracketMarks {
+			if mark.pos.Line == lineIdx && mark.pos.Col >= from && mark.pos.Col < to {
+				if mark.unmatched {
+					styles[mark.pos.Col-from] = e.Theme.BadBracket
+				} else {
+					styles[mark.pos.Col-from] = e.Theme.Bracket
+				}
+			}
+		}
+		blankStyle := bgStyle
+		if active && lineIdx == buf.CursorY && cursorLineBg != tcell.ColorDefault {
+			blankStyle = blankStyle.Background(cursorLineBg)
+			for i, style := range styles {
+				if _, bg, _ := style.Decompose(); bg == themeBg {
+					styles[i] = style.Background(cursorLineBg)
+				}
+			}
+		}
+
+		eolCursor := buf.extraCursorAt(lineIdx, len(runes))
+
 		// If we overshot due to a tab, fill with spaces
 		if visualCol > buf.OffsetX {
 			for screenCol < visualCol-buf.OffsetX && screenCol < textAreaWidth {
-				cellStyle := buf.GetStyleAt(lineIdx, charIdx-1)
-				if buf.Selection.Active && e.isSelected(buf, lineIdx, charIdx-1) {
-					cellStyle = selStyle
-				}
-				e.Screen.SetContent(pane.X+gutterWidth+screenCol, pane.Y+row, ' ', nil, cellStyle)
+				e.setCell(pane, gutterWidth+screenCol, row, ' ', styles[0])
 				screenCol++
 			}
 		}
 
 		// Render visible characters
 		for screenCol < textAreaWidth {
-			if charIdx >= len(runes) {
-				e.Screen.SetContent(pane.X+gutterWidth+screenCol, pane.Y+row, ' ', nil, tcell.StyleDefault)
+			if charIdx >= to {
+				style := blankStyle
+				if eolCursor && charIdx == len(runes) {
+					style = style.Reverse(true)
+					eolCursor = false
+				}
+				e.setCell(pane, gutterWidth+screenCol, row, ' ', style)
 				screenCol++
 				continue
 			}
 
 			ch := runes[charIdx]
-			cellStyle := buf.GetStyleAt(lineIdx, charIdx)
-			if buf.Selection.Active && e.isSelected(buf, lineIdx, charIdx) {
-				cellStyle = selStyle
-			} else if e.isSearchMatch(lineIdx, charIdx) {
-				cellStyle = searchStyle
-			}
-
+			cellStyle := styles[charIdx-from]
 			if ch == '\t' {
 				tabSpaces := tabWidth - ((buf.OffsetX + screenCol) % tabWidth)
 				for i := 0; i < tabSpaces && screenCol < textAreaWidth; i++ {
-					e.Screen.SetContent(pane.X+gutterWidth+screenCol, pane.Y+row, ' ', nil, cellStyle)
+					e.setCell(pane, gutterWidth+screenCol, row, ' ', cellStyle)
 					screenCol++
 				}
 			} else {
-				e.Screen.SetContent(pane.X+gutterWidth+screenCol, pane.Y+row, ch, nil, cellStyle)
+				e.setCell(pane, gutterWidth+screenCol, row, ch, cellStyle)
 				screenCol++
 			}
 			charIdx++
@@ -550,11 +668,11 @@ func (e *Editor) DrawPane(pane *Pane, active bool) {
 }
 
 func (e *Editor) charToVisualCol(buf *Buffer, line, charCol int) int {
-	const tabWidth = 4
-	if line >= len(buf.Lines) {
+	tabWidth := e.tabWidth(buf)
+	if line >= buf.LineCount() {
 		return charCol
 	}
-	runes := []rune(buf.Lines[line])
+	runes := []rune(buf.Line(line))
 	visualCol := 0
 	for i := 0; i < charCol && i < len(runes); i++ {
 		if runes[i] == '\t' {
@@ -566,52 +684,46 @@ func (e *Editor) charToVisualCol(buf *Buffer, line, charCol int) int {
 	return visualCol
 }
 
-func (e *Editor) isSelected(buf *Buffer, line, col int) bool {
-	if !buf.Selection.Active {
-		return false
-	}
-	startLine, startCol := buf.Selection.StartLine, buf.Selection.StartCol
-	endLine, endCol := buf.Selection.EndLine, buf.Selection.EndCol
-	
-	if startLine > endLine || (startLine == endLine && startCol > endCol) {
-		startLine, endLine = endLine, startLine
-		startCol, endCol = endCol, startCol
-	}
-	
-	if line < startLine || line > endLine {
-		return false
-	}
-	if line == startLine && line == endLine {
-		return col >= startCol && col < endCol
-	}
-	if line == startLine {
-		return col >= startCol
-	}
-	if line == endLine {
-		return col < endCol
-	}
-	return true
-}
-
-func (e *Editor) isSearchMatch(line, col int) bool {
-	for _, match := range e.SearchMatches {
-		if match.Line == line && col >= match.Col && col < match.Col+match.Len {
-			return true
-		}
-	}
-	return false
-}
-
 func (e *Editor) DrawStatusBar() {
 	w, h := e.Screen.Size()
-	style := tcell.StyleDefault.Background(tcell.ColorGray).Foreground(tcell.ColorBlack)
+	style := e.Theme.Status
 	
 	buf := e.CurrentBuffer()
 	filename := buf.Filename
 	if filename == "" {
 		filename = "[No Name]"
 	}
-	status := fmt.Sprintf(" %s | Line %d/%d, Col %d ", filename, buf.CursorY+1, len(buf.Lines), buf.CursorX+1)
+	if buf.ReadOnly || buf.ANSI != nil {
+		filename += " [RO]"
+	}
+	if buf.Follow != nil {
+		filename += " [follow]"
+	}
+	status := fmt.Sprintf(" %s | Line %d/%d, Col %d ", filename, buf.CursorY+1, buf.LineCount(), buf.CursorX+1)
+	if buf.Large != nil {
+		if progress := buf.Large.Progress(); progress != "" {
+			status += "| " + progress + " "
+		}
+	}
+	if buf.Hex != nil {
+		pane := "hex"
+		if buf.Hex.ASCII {
+			pane = "ascii"
+		}
+		status = fmt.Sprintf(" %s [%s] | Offset 0x%08x (%d)/%d ", filename, pane, buf.Hex.Cursor, buf.Hex.Cursor, len(buf.Hex.Data))
+	}
+	if buf.Pager {
+		status += "| PAGER "
+	}
+	if n := len(buf.Cursors); n > 0 {
+		status += fmt.Sprintf("| %d cursors ", n+1)
+	}
+	if e.Config.Keys == "vi" {
+		status += "| " + e.viModeName() + " "
+	}
+	if r := e.macro.recording; r != 0 {
+		status += fmt.Sprintf("| recording @%c ", unicode.ToLower(r))
+	}
 	
 	for i := 0; i < w; i++ {
 		ch := ' '
@@ -624,7 +736,7 @@ func (e *Editor) DrawStatusBar() {
 
 func (e *Editor) DrawCommandBar() {
 	w, h := e.Screen.Size()
-	style := tcell.StyleDefault
+	style := e.Theme.Background
 	
 	var text string
 	if e.SearchMode {
@@ -635,12 +747,20 @@ func (e *Editor) DrawCommandBar() {
 		text = e.StatusMsg
 	}
 	
+	line := text
+	if e.CommandMode && e.CommandHint != "" {
+		line += "  " + e.CommandHint
+	}
 	for i := 0; i < w; i++ {
 		ch := ' '
-		if i < len(text) {
-			ch = rune(text[i])
+		if i < len(line) {
+			ch = rune(line[i])
+		}
+		cellStyle := style
+		if i >= len(text) {
+			cellStyle = e.Theme.Gutter
 		}
-		e.Screen.SetContent(i, h-1, ch, nil, style)
+		e.Screen.SetContent(i, h-1, ch, nil, cellStyle)
 	}
 	
 	if e.CommandMode {
@@ -653,275 +773,115 @@ func (e *Editor) DrawCommandBar() {
 func (e *Editor) HandleEvent(ev tcell.Event) bool {
 	switch ev := ev.(type) {
 	case *tcell.EventResize:
+		e.InvalidateFrames()
 		e.Screen.Sync()
 		return true
 	case *tcell.EventKey:
-		return e.HandleKey(ev)
+		if e.pasting {
+			e.pasteKey(ev)
+			return true
+		}
+		buf := e.beginEdit()
+		e.recordKey(ev, true)
+		e.macro.played = false
+		running := e.HandleKey(ev)
+		e.recordKey(ev, false)
+		// A macro is its own undo step, even if it ends typing
+		e.endEdit(buf, e.typed(ev) && !e.macro.played)
+		return running
+	case *tcell.EventPaste:
+		e.HandlePaste(ev)
+	case *tcell.EventMouse:
+		e.HandleMouse(ev)
+	case *followEvent:
+		e.AppendFollowed(ev)
+	case *largeFileEvent:
+		e.HandleLargeFileEvent(ev)
+	case *largeSearchEvent:
+		e.HandleLargeSearchEvent(ev)
+	case *highlightEvent:
+		e.HandleHighlightEvent(ev)
 	}
 	return true
 }
 
 func (e *Editor) HandleKey(ev *tcell.EventKey) bool {
+	if e.picker != nil {
+		return e.HandlePickerKey(ev)
+	}
+	if e.registerAction != nil {
+		e.handleRegisterKey(ev)
+		return true
+	}
 	if e.CommandMode {
 		return e.HandleCommandKey(ev)
 	}
 	if e.SearchMode {
 		return e.HandleSearchKey(ev)
 	}
+	if e.CurrentBuffer().Pager && e.HandlePagerKey(ev) {
+		return true
+	}
 	
-	buf := e.CurrentBuffer()
-	pane := e.CurrentPane()
+	if e.CurrentBuffer().Hex != nil && e.HandleHexKey(ev) {
+		return true
+	}
 	
-	switch ev.Key() {
-	case tcell.KeyEscape:
-		buf.Selection.Active = false
-		e.SearchMatches = nil
-		e.SearchQuery = ""
-		e.StatusMsg = ""
-		
-	case tcell.KeyCtrlW:
-		if len(e.Panes) > 1 {
-			e.ActivePane = (e.ActivePane + 1) % len(e.Panes)
-		}
-		
-	case tcell.KeyCtrlC:
-		if buf.Selection.Active {
-			text := buf.GetSelectedText()
-			clipboard.WriteAll(text)
-			e.StatusMsg = "Copied to clipboard"
-		}
-		
-	case tcell.KeyCtrlV:
-		text, _ := clipboard.ReadAll()
-		if text != "" {
-			if buf.Selection.Active {
-				buf.DeleteSelection()
-			}
-			e.InsertText(text)
-			e.StatusMsg = "Pasted from clipboard"
-		}
-		
-	case tcell.KeyCtrlX:
-		if buf.Selection.Active {
-			text := buf.GetSelectedText()
-			clipboard.WriteAll(text)
-			buf.DeleteSelection()
-			e.StatusMsg = "Cut to clipboard"
-		}
-		
-	case tcell.KeyCtrlQ:
-		e.Screen.Fini()
-		os.Exit(0)
-		
-	case tcell.KeyCtrlS:
-		if err := buf.SaveFile(); err != nil {
-			e.StatusMsg = fmt.Sprintf("Error: %v", err)
-		} else {
-			e.StatusMsg = "Saved"
-		}
-		
-	case tcell.KeyCtrlE:
-		e.CommandMode = true
-		e.Command = ""
-		
-	case tcell.KeyCtrlF:
-		e.SearchMode = true
-		e.SearchQuery = ""
-		e.SearchMatches = nil
-		e.SearchIndex = 0
-		
-	case tcell.KeyUp:
-		selecting := ev.Modifiers()&tcell.ModCtrl != 0
-		if selecting && !buf.Selection.Active {
-			buf.Selection.Active = true
-			buf.Selection.StartLine = buf.CursorY
-			buf.Selection.StartCol = buf.CursorX
-		}
-		if buf.CursorY > 0 {
-			buf.CursorY--
-			lineLen := len([]rune(buf.Lines[buf.CursorY]))
-			if buf.CursorX > lineLen {
-				buf.CursorX = lineLen
-			}
-		}
-		if selecting {
-			buf.Selection.EndLine = buf.CursorY
-			buf.Selection.EndCol = buf.CursorX
-		} else {
-			buf.Selection.Active = false
-		}
-		e.ScrollToCursor(pane)
-		
-	case tcell.KeyDown:
-		selecting := ev.Modifiers()&tcell.ModCtrl != 0
-		if selecting && !buf.Selection.Active {
-			buf.Selection.Active = true
-			buf.Selection.StartLine = buf.CursorY
-			buf.Selection.StartCol = buf.CursorX
-		}
-		if buf.CursorY < len(buf.Lines)-1 {
-			buf.CursorY++
-			lineLen := len([]rune(buf.Lines[buf.CursorY]))
-			if buf.CursorX > lineLen {
-				buf.CursorX = lineLen
-			}
-		}
-		if selecting {
-			buf.Selection.EndLine = buf.CursorY
-			buf.Selection.EndCol = buf.CursorX
-		} else {
-			buf.Selection.Active = false
-		}
-		e.ScrollToCursor(pane)
-		
-	case tcell.KeyLeft:
-		selecting := ev.Modifiers()&tcell.ModCtrl != 0
-		wordJumpSelect := ev.Modifiers()&(tcell.ModCtrl|tcell.ModAlt) == (tcell.ModCtrl|tcell.ModAlt)
-		wordJumpNoSelect := ev.Modifiers() == tcell.ModAlt
-		if (selecting || wordJumpSelect) && !buf.Selection.Active {
-			buf.Selection.Active = true
-			buf.Selection.StartLine = buf.CursorY
-			buf.Selection.StartCol = buf.CursorX
-		}
-		if wordJumpSelect || wordJumpNoSelect {
-			buf.MoveWordLeft()
-		} else if buf.CursorX > 0 {
-			buf.CursorX--
-		} else if buf.CursorY > 0 {
-			buf.CursorY--
-			buf.CursorX = len([]rune(buf.Lines[buf.CursorY]))
-		}
-		if selecting || wordJumpSelect {
-			buf.Selection.EndLine = buf.CursorY
-			buf.Selection.EndCol = buf.CursorX
-		} else {
-			buf.Selection.Active = false
-		}
-		e.ScrollToCursor(pane)
-		
-	case tcell.KeyRight:
-		selecting := ev.Modifiers()&tcell.ModCtrl != 0
-		wordJumpSelect := ev.Modifiers()&(tcell.ModCtrl|tcell.ModAlt) == (tcell.ModCtrl|tcell.ModAlt)
-		wordJumpNoSelect := ev.Modifiers() == tcell.ModAlt
-		if (selecting || wordJumpSelect) && !buf.Selection.Active {
-			buf.Selection.Active = true
-			buf.Selection.StartLine = buf.CursorY
-			buf.Selection.StartCol = buf.CursorX
-		}
-		lineLen := len([]rune(buf.Lines[buf.CursorY]))
-		if wordJumpSelect || wordJumpNoSelect {
-			buf.MoveWordRight()
-		} else if buf.CursorX < lineLen {
-			buf.CursorX++
-		} else if buf.CursorY < len(buf.Lines)-1 {
-			buf.CursorY++
-			buf.CursorX = 0
-		}
-		if selecting || wordJumpSelect {
-			buf.Selection.EndLine = buf.CursorY
-			buf.Selection.EndCol = buf.CursorX
-		} else {
-			buf.Selection.Active = false
-		}
-		e.ScrollToCursor(pane)
-		
-	case tcell.KeyEnter:
-		if buf.Selection.Active {
-			buf.DeleteSelection()
-		}
-		runes := []rune(buf.Lines[buf.CursorY])
-		if buf.CursorX > len(runes) {
-			buf.CursorX = len(runes)
-		}
-		buf.Lines[buf.CursorY] = string(runes[:buf.CursorX])
-		newLine := string(runes[buf.CursorX:])
-		buf.Lines = append(buf.Lines[:buf.CursorY+1], append([]string{newLine}, buf.Lines[buf.CursorY+1:]...)...)
-		buf.MarkDirtyLines(buf.CursorY, len(buf.Lines)-1)
-		buf.CursorY++
-		buf.CursorX = 0
-		e.ScrollToCursor(pane)
-		
-	case tcell.KeyBackspace, tcell.KeyBackspace2:
-		if buf.Selection.Active {
-			buf.DeleteSelection()
-		} else if buf.CursorX > 0 {
-			runes := []rune(buf.Lines[buf.CursorY])
-			if buf.CursorX > len(runes) {
-				buf.CursorX = len(runes)
-			}
-			if buf.CursorX > 0 {
-				buf.Lines[buf.CursorY] = string(runes[:buf.CursorX-1]) + string(runes[buf.CursorX:])
-				buf.CursorX--
-				buf.MarkDirty()
-			}
-		} else if buf.CursorY > 0 {
-			prevRunes := []rune(buf.Lines[buf.CursorY-1])
-			buf.CursorX = len(prevRunes)
-			buf.Lines[buf.CursorY-1] = buf.Lines[buf.CursorY-1] + buf.Lines[buf.CursorY]
-			buf.Lines = append(buf.Lines[:buf.CursorY], buf.Lines[buf.CursorY+1:]...)
-			buf.CursorY--
-			buf.MarkDirtyLines(buf.CursorY, len(buf.Lines)-1)
-		}
-		e.ScrollToCursor(pane)
-		
-	case tcell.KeyDelete:
-		if buf.Selection.Active {
-			buf.DeleteSelection()
-		} else {
-			runes := []rune(buf.Lines[buf.CursorY])
-			if buf.CursorX < len(runes) {
-				buf.Lines[buf.CursorY] = string(runes[:buf.CursorX]) + string(runes[buf.CursorX+1:])
-				buf.MarkDirty()
-			} else if buf.CursorY < len(buf.Lines)-1 {
-				buf.Lines[buf.CursorY] = buf.Lines[buf.CursorY] + buf.Lines[buf.CursorY+1]
-				buf.Lines = append(buf.Lines[:buf.CursorY+1], buf.Lines[buf.CursorY+2:]...)
-				buf.MarkDirtyLines(buf.CursorY, len(buf.Lines)-1)
-			}
-		}
-		
-	case tcell.KeyTab:
-		if buf.Selection.Active {
-			buf.DeleteSelection()
-		}
-		e.InsertText("\t")
-		
-	case tcell.KeyRune:
+	if e.Config.Keys == "vi" && e.HandleViKey(ev) {
+		return true
+	}
+	
+	if e.HandleMappedKey(ev) {
+		return true
+	}
+	
+	e.prevAction, e.lastAction = e.lastAction, ""
+	if ev.Key() == tcell.KeyRune {
 		if ev.Rune() == 'n' && len(e.SearchMatches) > 0 {
-			e.SearchIndex = (e.SearchIndex + 1) % len(e.SearchMatches)
-			e.JumpToSearchMatch()
+			e.SearchNext()
 			return true
 		}
 		if ev.Rune() == 'N' && len(e.SearchMatches) > 0 {
-			e.SearchIndex = (e.SearchIndex - 1 + len(e.SearchMatches)) % len(e.SearchMatches)
-			e.JumpToSearchMatch()
+			e.SearchPrev()
 			return true
 		}
-		if buf.Selection.Active {
-			buf.DeleteSelection()
-		}
-		runes := []rune(buf.Lines[buf.CursorY])
-		if buf.CursorX > len(runes) {
-			buf.CursorX = len(runes)
-		}
-		buf.Lines[buf.CursorY] = string(runes[:buf.CursorX]) + string(ev.Rune()) + string(runes[buf.CursorX:])
-		buf.CursorX++
-		buf.MarkDirty()
-		e.ScrollToCursor(pane)
+		e.typeRune(ev.Rune())
 	}
 	
 	return true
 }
 
+func (e *Editor) readOnly(buf *Buffer) bool {
+	if buf.ReadOnly {
+		e.StatusMsg = "Buffer is read-only"
+		return true
+	}
+	if buf.ANSI != nil {
+		// Edits would go to the stripped lines, but the raw ones are saved
+		e.StatusMsg = "Buffer is read-only while ANSI rendering is on"
+		return true
+	}
+	return false
+}
+
 func (e *Editor) HandleCommandKey(ev *tcell.EventKey) bool {
+	e.CommandHint = ""
 	switch ev.Key() {
 	case tcell.KeyEscape:
 		e.CommandMode = false
 		e.Command = ""
 		
 	case tcell.KeyEnter:
+		e.addCommandHistory(strings.TrimSpace(e.Command))
 		e.ExecuteCommand()
 		e.CommandMode = false
 		e.Command = ""
+
+	case tcell.KeyUp:
+		e.recallCommand(-1)
+
+	case tcell.KeyDown:
+		e.recallCommand(1)
 		
 	case tcell.KeyBackspace, tcell.KeyBackspace2:
 		if len(e.Command) > 0 {
@@ -947,7 +907,7 @@ func (e *Editor) TabCompleteCommand() {
 	
 	// Complete command name
 	if len(parts) == 1 && !strings.HasSuffix(e.Command, " ") {
-		commands := []string{"quit", "write", "wq", "edit", "hsplit", "vsplit", "close", "goto"}
+		commands := []string{"quit", "write", "wq", "edit", "hsplit", "vsplit", "close", "goto", "follow", "ansi", "colorscheme", "colors", "set", "setlocal", "reload-config", "map", "yank", "put", "registers", "macros", "play"}
 		var matches []string
 		for _, cmd := range commands {
 			if strings.HasPrefix(cmd, parts[0]) {
@@ -960,8 +920,17 @@ func (e *Editor) TabCompleteCommand() {
 		return
 	}
 	
-	// Complete filename for e, hsplit, vsplit
 	cmd := parts[0]
+	if cmd == "colorscheme" {
+		e.completeColorscheme(parts[1:])
+		return
+	}
+	if cmd == "map" {
+		e.completeAction(parts[1:])
+		return
+	}
+
+	// Complete filename for e, hsplit, vsplit
 	if cmd != "e" && cmd != "edit" && cmd != "hsplit" && cmd != "vsplit" {
 		return
 	}
@@ -1019,6 +988,7 @@ func (e *Editor) HandleSearchKey(ev *tcell.EventKey) bool {
 	case tcell.KeyEscape:
 		e.SearchMode = false
 		e.SearchQuery = ""
+		e.CancelSearch()
 		e.SearchMatches = nil
 		
 	case tcell.KeyEnter:
@@ -1044,21 +1014,30 @@ func (e *Editor) ExecuteSearch() {
 	}
 	
 	buf := e.CurrentBuffer()
+	e.CancelSearch()
 	e.SearchMatches = nil
-	
-	for lineIdx, line := range buf.Lines {
-		start := 0
-		for {
-			idx := strings.Index(line[start:], e.SearchQuery)
-			if idx == -1 {
-				break
+	if buf.Large != nil {
+		e.StartLargeSearch(buf, e.SearchQuery, e.searchID)
+		e.StatusMsg = "Searching..."
+		return
+	}
+	if buf.Hex != nil {
+		e.ExecuteHexSearch()
+	} else {
+		for lineIdx, line := range buf.Lines {
+			start := 0
+			for {
+				idx := strings.Index(line[start:], e.SearchQuery)
+				if idx == -1 {
+					break
+				}
+				e.SearchMatches = append(e.SearchMatches, SearchMatch{
+					Line: lineIdx,
+					Col:  start + idx,
+					Len:  len(e.SearchQuery),
+				})
+				start += idx + 1
 			}
-			e.SearchMatches = append(e.SearchMatches, SearchMatch{
-				Line: lineIdx,
-				Col:  start + idx,
-				Len:  len(e.SearchQuery),
-			})
-			start += idx + 1
 		}
 	}
 	
@@ -1068,6 +1047,15 @@ func (e *Editor) ExecuteSearch() {
 		e.StatusMsg = fmt.Sprintf("Found %d matches", len(e.SearchMatches))
 	} else {
 		e.StatusMsg = "No matches found"
+		e.motionFailed = true
+	}
+}
+
+// CancelSearch stops a streaming search so its late results are dropped.
+func (e *Editor) CancelSearch() {
+	e.searchID++
+	if buf := e.CurrentBuffer(); buf.Large != nil {
+		buf.Large.stopSearch()
 	}
 }
 
@@ -1080,6 +1068,12 @@ func (e *Editor) JumpToSearchMatch() {
 	buf := e.CurrentBuffer()
 	pane := e.CurrentPane()
 	
+	if buf.Hex != nil {
+		buf.Hex.moveTo(match.Col)
+		e.ScrollHexToCursor(pane)
+		e.StatusMsg = fmt.Sprintf("Match %d/%d", e.SearchIndex+1, len(e.SearchMatches))
+		return
+	}
 	buf.CursorY = match.Line
 	buf.CursorX = match.Col
 	e.ScrollToCursor(pane)
@@ -1097,8 +1091,11 @@ func (e *Editor) ExecuteCommand() {
 	
 	switch cmd {
 	case "q", "quit":
-		e.Screen.Fini()
-		os.Exit(0)
+		if err := e.autoSaveAll(); err != nil {
+			e.StatusMsg = fmt.Sprintf("Error: %v", err)
+			return
+		}
+		e.exit()
 		
 	case "w", "write":
 		buf := e.CurrentBuffer()
@@ -1116,8 +1113,7 @@ func (e *Editor) ExecuteCommand() {
 		if err := buf.SaveFile(); err != nil {
 			e.StatusMsg = fmt.Sprintf("Error: %v", err)
 		} else {
-			e.Screen.Fini()
-			os.Exit(0)
+			e.exit()
 		}
 		
 	case "e", "edit":
@@ -1126,7 +1122,9 @@ func (e *Editor) ExecuteCommand() {
 			return
 		}
 		buf := e.CurrentBuffer()
-		if err := buf.LoadFile(args[0]); err != nil {
+		e.autoSave(buf)
+		buf.StopFollow()
+		if err := e.OpenFile(buf, args[0]); err != nil {
 			e.StatusMsg = fmt.Sprintf("Error: %v", err)
 		} else {
 			buf.CursorX = 0
@@ -1139,8 +1137,9 @@ func (e *Editor) ExecuteCommand() {
 	case "hsplit":
 		if len(e.Panes) < 2 {
 			newBuf := NewBuffer()
+			newBuf.Style = e.Theme.Syntax
 			if len(args) > 0 {
-				if err := newBuf.LoadFile(args[0]); err != nil {
+				if err := e.OpenFile(newBuf, args[0]); err != nil {
 					e.StatusMsg = fmt.Sprintf("Error: %v", err)
 					return
 				}
@@ -1156,8 +1155,9 @@ func (e *Editor) ExecuteCommand() {
 	case "vsplit":
 		if len(e.Panes) < 2 {
 			newBuf := NewBuffer()
+			newBuf.Style = e.Theme.Syntax
 			if len(args) > 0 {
-				if err := newBuf.LoadFile(args[0]); err != nil {
+				if err := e.OpenFile(newBuf, args[0]); err != nil {
 					e.StatusMsg = fmt.Sprintf("Error: %v", err)
 					return
 				}
@@ -1172,11 +1172,14 @@ func (e *Editor) ExecuteCommand() {
 		
 	case "close":
 		if len(e.Panes) > 1 {
+			e.CurrentBuffer().StopFollow()
+			e.CurrentBuffer().closeLarge()
 			e.Panes = append(e.Panes[:e.ActivePane], e.Panes[e.ActivePane+1:]...)
 			if e.ActivePane >= len(e.Panes) {
 				e.ActivePane = len(e.Panes) - 1
 			}
 			e.SplitType = SplitNone
+			e.splitSize = 0
 		}
 
 	case "goto", "g":
@@ -1184,60 +1187,189 @@ func (e *Editor) ExecuteCommand() {
 			e.StatusMsg = "Usage: goto <line>"
 			return
 		}
+		if e.CurrentBuffer().Hex != nil {
+			e.GotoOffset(args[0])
+			return
+		}
 		lineNum, err := strconv.Atoi(args[0])
 		if err != nil {
 			e.StatusMsg = "Invalid line number"
 			return
 		}
 		buf := e.CurrentBuffer()
-		pane := e.CurrentPane()
 		lineNum-- // Convert to 0-indexed
-		if lineNum < 0 {
-			lineNum = 0
-		} else if lineNum >= len(buf.Lines) {
-			lineNum = len(buf.Lines) - 1
-		}
-		buf.CursorY = lineNum
-		buf.CursorX = 0
-		e.ScrollToCursor(pane)
-		e.StatusMsg = fmt.Sprintf("Line %d", lineNum+1)
+		if buf.Large != nil && lineNum >= buf.LineCount() {
+			// Jump once the indexer gets there
+			buf.Large.PendingGoto = lineNum
+			e.StatusMsg = fmt.Sprintf("Line %d not indexed yet, jumping when it is", lineNum+1)
+			return
+		}
+		e.gotoLine(e.CurrentPane(), lineNum)
+
+	case "follow":
+		buf := e.CurrentBuffer()
+		if buf.Follow != nil {
+			buf.StopFollow()
+			e.StatusMsg = "Stopped following"
+			return
+		}
+		if err := e.StartFollow(buf); err != nil {
+			e.StatusMsg = fmt.Sprintf("Error: %v", err)
+			return
+		}
+		e.ScrollToCursor(e.CurrentPane())
+		e.StatusMsg = fmt.Sprintf("Following: %s", buf.Filename)
+
+	case "ansi":
+		buf := e.CurrentBuffer()
+		if buf.ANSI != nil {
+			buf.DisableANSI()
+			e.StatusMsg = "ANSI rendering off"
+			return
+		}
+		if !buf.ReadOnly {
+			e.StatusMsg = "ANSI rendering is only available for read-only buffers"
+			return
+		}
+		if buf.Large != nil {
+			e.StatusMsg = "ANSI rendering is not available for large files"
+			return
+		}
+		buf.EnableANSI()
+		e.StatusMsg = "ANSI rendering on"
+
+	case "set":
+		if len(args) < 1 {
+			var settings []string
+			for _, name := range optionNames() {
+				settings = append(settings, name+"="+configOptions[name].get(e))
+			}
+			e.StatusMsg = strings.Join(settings, " ")
+			return
+		}
+		var results []string
+		for _, arg := range args {
+			result, err := e.SetOption(arg)
+			if err != nil {
+				e.StatusMsg = fmt.Sprintf("Error: %v", err)
+				return
+			}
+			results = append(results, result)
+		}
+		e.StatusMsg = strings.Join(results, " ")
+
+	case "setlocal":
+		buf := e.CurrentBuffer()
+		if len(args) < 1 {
+			e.StatusMsg = fmt.Sprintf("tabwidth=%d shiftwidth=%d expandtab=%t %s", e.tabWidth(buf), e.shiftWidth(buf), e.expandTab(buf), buf.Format.name())
+			return
+		}
+		var results []string
+		for _, arg := range args {
+			result, err := e.SetLocalOption(arg)
+			if err != nil {
+				e.StatusMsg = fmt.Sprintf("Error: %v", err)
+				return
+			}
+			results = append(results, result)
+		}
+		e.StatusMsg = strings.Join(results, " ")
+
+	case "yank", "put":
+		e.registerCommand(cmd, args)
+
+	case "registers", "reg":
+		e.ShowClipboardHistory()
+
+	case "macros", "play":
+		e.macroCommand(cmd, args)
+
+	case "map":
+		if len(args) < 1 {
+			e.StatusMsg = strings.Join(e.bindings(), " ")
+			return
+		}
+		action := args[len(args)-1]
+		if _, ok := editorActions[action]; !ok && action != "none" {
+			seq, err := parseKeys(strings.Join(args, " "))
+			if err != nil {
+				e.StatusMsg = fmt.Sprintf("Error: %v", err)
+				return
+			}
+			if bound, ok := e.Keymap[seq]; ok {
+				e.StatusMsg = fmt.Sprintf("%s: %s", seq, bound)
+			} else {
+				e.StatusMsg = fmt.Sprintf("%s is not mapped", seq)
+			}
+			return
+		}
+		if len(args) < 2 {
+			e.StatusMsg = "Usage: map <keys> <action>"
+			return
+		}
+		keys := strings.Join(args[:len(args)-1], " ")
+		if err := e.Map(keys, action); err != nil {
+			e.StatusMsg = fmt.Sprintf("Error: %v", err)
+			return
+		}
+		e.StatusMsg = fmt.Sprintf("Mapped %s to %s", keys, action)
+
+	case "reload-config":
+		if err := e.LoadConfig(); err != nil {
+			e.StatusMsg = fmt.Sprintf("Error: %v", err)
+			return
+		}
+		e.StatusMsg = "Config reloaded"
+
+	case "colors":
+		ps, ok := e.Screen.(*paletteScreen)
+		if len(args) < 1 && ok {
+			e.StatusMsg = fmt.Sprintf("Colors: %s (detected %s)", colorDepthName(ps.Depth()), colorDepthName(ps.Detected))
+			return
+		}
+		if len(args) < 1 {
+			e.StatusMsg = "Usage: colors <auto|truecolor|256|16|8|none>"
+			return
+		}
+		if err := e.SetColorDepth(args[0]); err != nil {
+			e.StatusMsg = fmt.Sprintf("Error: %v", err)
+			return
+		}
+		e.StatusMsg = fmt.Sprintf("Colors: %s", args[0])
+
+	case "colorscheme":
+		if len(args) < 1 {
+			e.StatusMsg = fmt.Sprintf("Colorscheme: %s", e.Theme.Name)
+			return
+		}
+		theme, err := LoadTheme(args[0])
+		if err != nil {
+			e.StatusMsg = fmt.Sprintf("Error: %v", err)
+			return
+		}
+		e.SetTheme(theme)
+		e.StatusMsg = fmt.Sprintf("Colorscheme: %s", theme.Name)
 		
 	default:
 		e.StatusMsg = fmt.Sprintf("Unknown command: %s", cmd)
 	}
 }
 
-func (e *Editor) InsertText(text string) {
-	buf := e.CurrentBuffer()
-	lines := strings.Split(text, "\n")
-	startLine := buf.CursorY
-	
-	if len(lines) == 1 {
-		line := buf.Lines[buf.CursorY]
-		buf.Lines[buf.CursorY] = line[:buf.CursorX] + text + line[buf.CursorX:]
-		buf.CursorX += len(text)
-		buf.MarkDirty()
-	} else {
-		currentLine := buf.Lines[buf.CursorY]
-		beforeCursor := currentLine[:buf.CursorX]
-		afterCursor := currentLine[buf.CursorX:]
-		
-		buf.Lines[buf.CursorY] = beforeCursor + lines[0]
-		
-		newLines := make([]string, 0, len(buf.Lines)+len(lines)-1)
-		newLines = append(newLines, buf.Lines[:buf.CursorY+1]...)
-		for i := 1; i < len(lines)-1; i++ {
-			newLines = append(newLines, lines[i])
-		}
-		lastNewLine := lines[len(lines)-1] + afterCursor
-		newLines = append(newLines, lastNewLine)
-		newLines = append(newLines, buf.Lines[buf.CursorY+1:]...)
-		
-		buf.Lines = newLines
-		buf.CursorY += len(lines) - 1
-		buf.CursorX = len(lines[len(lines)-1])
-		buf.MarkDirtyLines(startLine, len(buf.Lines)-1)
+func (e *Editor) gotoLine(pane *Pane, lineNum int) {
+	buf := pane.Buffer
+	if lineNum < 0 {
+		lineNum = 0
+	} else if lineNum >= buf.LineCount() {
+		lineNum = buf.LineCount() - 1
 	}
+	buf.CursorY = lineNum
+	buf.CursorX = 0
+	e.ScrollToCursor(pane)
+	e.StatusMsg = fmt.Sprintf("Line %d", lineNum+1)
+}
+
+func (e *Editor) InsertText(text string) {
+	e.CurrentBuffer().insertText(text)
 }
 
 func (e *Editor) ScrollToCursor(pane *Pane) {
@@ -1272,15 +1404,35 @@ func (e *Editor) Run() {
 }
 
 func main() {
+	// `accela -` or a pipe without arguments pages stdin
+	readStdin := (len(os.Args) > 1 && os.Args[1] == "-") || (len(os.Args) == 1 && stdinIsPipe())
+	var stdinData []byte
+	if readStdin {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", err)
+			os.Exit(1)
+		}
+		stdinData = data
+	}
+
 	editor, err := NewEditor()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 	defer editor.Screen.Fini()
+	if err := editor.LoadConfig(); err != nil {
+		editor.StatusMsg = fmt.Sprintf("Config error: %v", err)
+	}
+	if err := editor.loadHistory(); err != nil {
+		editor.StatusMsg = fmt.Sprintf("Error: %v", err)
+	}
 	
-	if len(os.Args) > 1 {
-		if err := editor.CurrentBuffer().LoadFile(os.Args[1]); err != nil {
+	if readStdin {
+		editor.CurrentBuffer().LoadUnnamed(stdinData)
+	} else if len(os.Args) > 1 {
+		if err := editor.OpenFile(editor.CurrentBuffer(), os.Args[1]); err != nil {
 			editor.StatusMsg = fmt.Sprintf("Error loading file: %v", err)
 		}
 	}
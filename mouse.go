@@ -0,0 +1,272 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+const (
+	multiClickTime = 400 * time.Millisecond
+	wheelLines     = 3
+)
+
+// mouseState follows the left button from press to release.
+type mouseState struct {
+	down      bool
+	resizing  bool     // dragging the split border
+	anchor    position // start of what the press selected
+	anchorEnd position // end of the word or line a double or triple click selected
+	clicks    int      // 1, 2 or 3 for single, double and triple clicks
+	lastClick time.Time
+	lastX     int
+	lastY     int
+}
+
+// applyMouse turns mouse reporting on or off. With it off the terminal
+// selects text itself.
+func (e *Editor) applyMouse() {
+	if e.Config.Mouse {
+		e.Screen.EnableMouse()
+	} else {
+		e.Screen.DisableMouse()
+	}
+}
+
+func (e *Editor) ToggleMouse() {
+	e.Config.Mouse = !e.Config.Mouse
+	e.applyMouse()
+	if e.Config.Mouse {
+		e.StatusMsg = "Mouse on"
+	} else {
+		e.StatusMsg = "Mouse off: the terminal selects text"
+	}
+}
+
+// splitAt returns the size of the first split out of total rows or columns.
+func (e *Editor) splitAt(total int) int {
+	if e.splitSize <= 0 {
+		return total / 2
+	}
+	return min(max(e.splitSize, 1), total-1)
+}
+
+func (e *Editor) paneAt(x, y int) int {
+	for i, pane := range e.Panes {
+		if x >= pane.X && x < pane.X+pane.Width && y >= pane.Y && y < pane.Y+pane.Height {
+			return i
+		}
+	}
+	return -1
+}
+
+// onSplitBorder reports whether x, y is on the first column or row of the
+// second split, which is where a drag resizes them.
+func (e *Editor) onSplitBorder(x, y int) bool {
+	if len(e.Panes) != 2 {
+		return false
+	}
+	if e.SplitType == SplitHorizontal {
+		return y == e.Panes[1].Y
+	}
+	return x == e.Panes[1].X
+}
+
+// screenToBuffer returns the position of the character drawn at x, y in
+// pane, or the end of the line or last line if x, y is past them.
+func (e *Editor) screenToBuffer(pane *Pane, x, y int) position {
+	buf := pane.Buffer
+	line := min(buf.OffsetY+max(y-pane.Y, 0), buf.LineCount()-1)
+	col := max(x-pane.X-pane.GutterWidth, 0) + buf.OffsetX
+	tabWidth := e.tabWidth(buf)
+	visual := 0
+	for i, r := range []rune(buf.Line(line)) {
+		width := 1
+		if r == '\t' {
+			width = tabWidth - visual%tabWidth
+		}
+		if visual+width > col {
+			return position{line, i}
+		}
+		visual += width
+	}
+	return position{line, buf.lineLen(line)}
+}
+
+func (e *Editor) HandleMouse(ev *tcell.EventMouse) {
+	x, y := ev.Position()
+	m := &e.mouse
+	buttons := ev.Buttons()
+	switch {
+	case buttons&tcell.WheelUp != 0:
+		e.scrollPaneAt(x, y, -wheelLines)
+	case buttons&tcell.WheelDown != 0:
+		e.scrollPaneAt(x, y, wheelLines)
+	case buttons&tcell.Button1 != 0:
+		if m.down {
+			e.mouseDrag(x, y)
+		} else {
+			m.down = true
+			e.mousePress(x, y, ev.Modifiers()&tcell.ModShift != 0)
+		}
+	case buttons&tcell.ButtonMiddle != 0:
+		if !m.down {
+			m.down = true
+			e.mousePress(x, y, false)
+			if buf := e.CurrentBuffer(); buf.Hex == nil && !m.resizing && !e.readOnly(buf) {
+				// Its own undo step, like a paste from the terminal
+				edit := e.beginEdit()
+				e.PastePrimary()
+				e.endEdit(edit, false)
+			}
+		}
+	default:
+		if m.down && !m.resizing {
+			// Like other X11 programs, what the mouse selects can be
+			// pasted with the middle button
+			buf := e.CurrentBuffer()
+			if start, end, ok := buf.region(); ok && buf.Hex == nil && start != end {
+				e.writePrimary(buf.textBetween(start, end))
+			}
+		}
+		m.down, m.resizing = false, false
+	}
+}
+
+// scrollPaneAt scrolls the pane under x, y without moving its cursor.
+func (e *Editor) scrollPaneAt(x, y, lines int) {
+	i := e.paneAt(x, y)
+	if i < 0 {
+		return
+	}
+	buf := e.Panes[i].Buffer
+	if h := buf.Hex; h != nil {
+		rows := (len(h.Data) + h.bytesPerRow() - 1) / h.bytesPerRow()
+		h.Top = min(max(h.Top+lines, 0), max(0, rows-1))
+		return
+	}
+	buf.OffsetY = min(max(buf.OffsetY+lines, 0), max(0, buf.LineCount()-1))
+}
+
+func (e *Editor) mousePress(x, y int, extend bool) {
+	m := &e.mouse
+	i := e.paneAt(x, y)
+	if i < 0 || e.CommandMode || e.SearchMode {
+		return
+	}
+	if e.onSplitBorder(x, y) && i == 1 && !extend {
+		// The press grabs the border, not text in the pane below it
+		m.resizing = true
+		return
+	}
+	if i != e.ActivePane {
+		e.autoSave(e.CurrentBuffer())
+		e.ActivePane = i
+	}
+	pane := e.CurrentPane()
+	buf := pane.Buffer
+	if buf.Hex != nil {
+		return
+	}
+	buf.Cursors = nil
+	pos := e.screenToBuffer(pane, x, y)
+
+	if time.Since(m.lastClick) < multiClickTime && x == m.lastX && y == m.lastY {
+		m.clicks = m.clicks%3 + 1
+	} else {
+		m.clicks = 1
+	}
+	m.lastClick, m.lastX, m.lastY = time.Now(), x, y
+
+	m.anchor, m.anchorEnd = pos, pos
+	switch m.clicks {
+	case 2:
+		if start, end, ok := wordAt([]rune(buf.Line(pos.Line)), pos.Col); ok {
+			m.anchor, m.anchorEnd = position{pos.Line, start}, position{pos.Line, end}
+		}
+	case 3:
+		m.anchor = position{pos.Line, 0}
+		m.anchorEnd = position{pos.Line, buf.lineLen(pos.Line)}
+		if pos.Line < buf.LineCount()-1 {
+			m.anchorEnd = position{pos.Line + 1, 0}
+		}
+	}
+	if extend && m.clicks == 1 {
+		// Shift-click keeps the start of the selection, or the cursor
+		if buf.Selection.Active {
+			m.anchor = position{buf.Selection.StartLine, buf.Selection.StartCol}
+		} else {
+			m.anchor = position{buf.CursorY, buf.CursorX}
+		}
+		m.anchorEnd = m.anchor
+	}
+	e.mouseSelect(pos)
+}
+
+func (e *Editor) mouseDrag(x, y int) {
+	m := &e.mouse
+	if m.resizing {
+		w, h := e.Screen.Size()
+		if e.SplitType == SplitHorizontal {
+			e.splitSize = min(max(y, 1), h-3)
+		} else {
+			e.splitSize = min(max(x, 1), w-1)
+		}
+		return
+	}
+	pane := e.CurrentPane()
+	if pane.Buffer.Hex != nil || e.CommandMode || e.SearchMode {
+		return
+	}
+	e.mouseSelect(e.screenToBuffer(pane, x, y))
+}
+
+// mouseSelect selects from the press to pos, whole words or lines after a
+// double or triple click.
+func (e *Editor) mouseSelect(pos position) {
+	m := &e.mouse
+	buf := e.CurrentBuffer()
+	start, cursor := m.anchor, pos
+	if pos.before(m.anchor) {
+		start = m.anchorEnd
+	} else if pos.before(m.anchorEnd) {
+		cursor = m.anchorEnd
+	}
+	buf.CursorY, buf.CursorX = cursor.Line, cursor.Col
+
+	if e.Config.Keys == "vi" && e.vi.Mode != viInsert {
+		if start == cursor {
+			if e.vi.Mode != viNormal {
+				e.viEnterNormal()
+			}
+			e.viClampCursor()
+			return
+		}
+		e.vi.Mode = viVisual
+		if m.clicks == 3 {
+			e.vi.Mode = viVisualLine
+		}
+		if start.before(cursor) {
+			// Visual mode includes the character under the cursor
+			if cursor.Col > 0 {
+				buf.CursorX--
+			} else {
+				buf.CursorY--
+				buf.CursorX = buf.lineLen(buf.CursorY)
+			}
+		}
+		e.vi.anchor = start
+		e.viClampCursor()
+		e.viUpdateSelection()
+		return
+	}
+	if start == cursor {
+		buf.Selection.Active = false
+		return
+	}
+	buf.Selection = Selection{
+		StartLine: start.Line, StartCol: start.Col,
+		EndLine: cursor.Line, EndCol: cursor.Col,
+		Active: true,
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func stdinIsPipe() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice == 0
+}
+
+// newScreen opens the screen on the controlling terminal. When stdin is a
+// pipe it carries the document, so keyboard input has to come from /dev/tty.
+func newScreen() (tcell.Screen, error) {
+	if !stdinIsPipe() {
+		return tcell.NewScreen()
+	}
+	tty, err := tcell.NewDevTty()
+	if err != nil {
+		return nil, err
+	}
+	return tcell.NewTerminfoScreenFromTty(tty)
+}
+
+func (b *Buffer) LoadUnnamed(data []byte) {
+	b.Filename = ""
+	b.ReadOnly = true
+	b.Pager = true
+	if isBinary(data) {
+		b.loadBinary(data)
+		return
+	}
+	b.setContent(string(data))
+	if hasANSI(b.Lines) {
+		b.EnableANSI()
+		return
+	}
+	b.SetupHighlighting()
+}
+
+func (e *Editor) ScrollPage(pane *Pane, dir int) {
+	buf := pane.Buffer
+	page := max(1, pane.Height-1)
+	maxOffset := max(0, buf.LineCount()-pane.Height)
+	buf.OffsetY = min(maxOffset, max(0, buf.OffsetY+dir*page))
+	buf.CursorY = min(buf.LineCount()-1, max(0, buf.CursorY+dir*page))
+	// Keep the cursor inside the new viewport
+	if buf.CursorY < buf.OffsetY {
+		buf.CursorY = buf.OffsetY
+	} else if buf.CursorY >= buf.OffsetY+pane.Height {
+		buf.CursorY = buf.OffsetY + pane.Height - 1
+	}
+	buf.CursorX = min(buf.CursorX, len([]rune(buf.Line(buf.CursorY))))
+}
+
+// HandlePagerKey handles less-style keys and reports whether ev was consumed.
+func (e *Editor) HandlePagerKey(ev *tcell.EventKey) bool {
+	buf := e.CurrentBuffer()
+	pane := e.CurrentPane()
+
+	if ev.Key() != tcell.KeyRune {
+		return false
+	}
+	switch ev.Rune() {
+	case 'q':
+		e.quitPager()
+	case ' ', 'f':
+		e.ScrollPage(pane, 1)
+	case 'b':
+		e.ScrollPage(pane, -1)
+	case 'j':
+		if buf.CursorY < buf.LineCount()-1 {
+			buf.CursorY++
+		}
+		buf.CursorX = 0
+		e.ScrollToCursor(pane)
+	case 'k':
+		if buf.CursorY > 0 {
+			buf.CursorY--
+		}
+		buf.CursorX = 0
+		e.ScrollToCursor(pane)
+	case 'g':
+		buf.CursorY = 0
+		buf.CursorX = 0
+		e.ScrollToCursor(pane)
+	case 'G':
+		buf.CursorY = buf.LineCount() - 1
+		buf.CursorX = 0
+		e.ScrollToCursor(pane)
+	case '/':
+		e.SearchMode = true
+		e.SearchQuery = ""
+		e.SearchMatches = nil
+		e.SearchIndex = 0
+	default:
+		return false
+	}
+	return true
+}
+
+// quitPager leaves accela like Quit, unless a file opened from the pager has
+// unsaved changes: q is one key, easily pressed without thinking of them.
+func (e *Editor) quitPager() {
+	if err := e.autoSaveAll(); err != nil {
+		e.StatusMsg = fmt.Sprintf("Error: %v", err)
+		return
+	}
+	for _, pane := range e.Panes {
+		if buf := pane.Buffer; buf.Modified && !buf.ReadOnly {
+			name := buf.Filename
+			if name == "" {
+				name = "[No Name]"
+			}
+			e.StatusMsg = fmt.Sprintf("%s has unsaved changes, use the quit command to quit anyway", name)
+			return
+		}
+	}
+	e.exit()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// HandlePaste collects a bracketed paste from the terminal and inserts it
+// all at once when it ends, instead of key by key.
+func (e *Editor) HandlePaste(ev *tcell.EventPaste) {
+	if ev.Start() {
+		e.pasting = true
+		e.pasted.Reset()
+		return
+	}
+	e.pasting = false
+	text := e.pasted.String()
+	e.pasted.Reset()
+	if text == "" {
+		return
+	}
+	if e.CommandMode || e.SearchMode {
+		// The bars hold one line
+		line, _, _ := strings.Cut(text, "\n")
+		if e.CommandMode {
+			e.Command += line
+		} else {
+			e.SearchQuery += line
+		}
+		return
+	}
+
+	buf := e.beginEdit()
+	defer e.endEdit(buf, false)
+	e.InsertPasted(text)
+}
+
+func (e *Editor) pasteKey(ev *tcell.EventKey) {
+	switch ev.Key() {
+	case tcell.KeyRune:
+		e.pasted.WriteRune(ev.Rune())
+	case tcell.KeyEnter, tcell.KeyLF:
+		e.pasted.WriteByte('\n')
+	case tcell.KeyTab:
+		e.pasted.WriteByte('\t')
+	}
+}
+
+// InsertPasted puts text in at the cursor, or at every cursor, replacing
+// the selection.
+func (e *Editor) InsertPasted(text string) {
+	buf := e.CurrentBuffer()
+	if buf.Hex != nil || e.readOnly(buf) {
+		return
+	}
+	if buf.Selection.Active && buf.Selection.Block {
+		e.blockToCursors()
+	}
+	if len(buf.Cursors) > 0 {
+		e.pasteAtCursors(text)
+	} else {
+		if buf.Selection.Active {
+			buf.DeleteSelection()
+		}
+		e.InsertText(text)
+	}
+	e.ScrollToCursor(e.CurrentPane())
+	lines := strings.Count(text, "\n")
+	if !strings.HasSuffix(text, "\n") {
+		lines++
+	}
+	if lines == 1 {
+		e.StatusMsg = "Pasted 1 line"
+	} else {
+		e.StatusMsg = fmt.Sprintf("Pasted %d lines", lines)
+	}
+}
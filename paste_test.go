@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// bracketedPaste sends text the way a terminal does when it is pasted.
+func bracketedPaste(e *Editor, text string) {
+	e.HandleEvent(tcell.NewEventPaste(true))
+	typeKeys(e, text)
+	e.HandleEvent(tcell.NewEventPaste(false))
+}
+
+func TestPaste(t *testing.T) {
+	tests := []struct {
+		name, text string
+		cursor     position
+		paste      string
+		want       string
+		wantCursor position
+	}{
+		{"ascii", "hello", position{0, 2}, "XY", "heXYllo", position{0, 4}},
+		{"after wide runes", "héllo", position{0, 2}, "ñ", "héñllo", position{0, 3}},
+		{"before wide runes", "héllo", position{0, 1}, "ñ", "hñéllo", position{0, 2}},
+		{"lines", "héllo", position{0, 2}, "ñ\nü", "héñ\nüllo", position{1, 1}},
+		{"ending in a newline", "añb", position{0, 2}, "é\n", "añé\nb", position{1, 0}},
+		{"past the end", "é", position{0, 1}, "ü", "éü", position{0, 2}},
+	}
+	for _, tt := range tests {
+		for _, how := range []string{"bracketed", "clipboard"} {
+			e := testEditor(t, tt.text)
+			e.Config.Clipboard = "internal"
+			buf := e.CurrentBuffer()
+			buf.CursorY, buf.CursorX = tt.cursor.Line, tt.cursor.Col
+			if how == "bracketed" {
+				bracketedPaste(e, tt.paste)
+			} else {
+				e.writeClipboard(tt.paste)
+				typeKeys(e, "\x16")
+			}
+			got, cursor := strings.Join(buf.Lines, "\n"), position{buf.CursorY, buf.CursorX}
+			if got != tt.want || cursor != tt.wantCursor {
+				t.Errorf("%s, %s: %q cursor %v, want %q %v", tt.name, how, got, cursor, tt.want, tt.wantCursor)
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// picker is a list drawn over the panes to choose one entry from.
+type picker struct {
+	title string
+	items []pickerItem
+	index int
+	top   int // first item shown
+	pick  func(pickerItem)
+}
+
+type pickerItem struct {
+	label string
+	text  string
+}
+
+func (e *Editor) openPicker(title string, items []pickerItem, pick func(pickerItem)) {
+	e.picker = &picker{title: title, items: items, pick: pick}
+	e.StatusMsg = "Up/Down to choose, Enter to paste, Esc to cancel"
+}
+
+func (e *Editor) closePicker() {
+	e.picker = nil
+	// The panes under it have to be drawn again
+	e.InvalidateFrames()
+}
+
+// HandlePickerKey moves through the picker, or picks or closes it.
+func (e *Editor) HandlePickerKey(ev *tcell.EventKey) bool {
+	p := e.picker
+	switch ev.Key() {
+	case tcell.KeyEscape, tcell.KeyCtrlG:
+		e.closePicker()
+		e.StatusMsg = ""
+	case tcell.KeyUp, tcell.KeyCtrlP:
+		p.index = (p.index - 1 + len(p.items)) % len(p.items)
+	case tcell.KeyDown, tcell.KeyCtrlN, tcell.KeyTab:
+		p.index = (p.index + 1) % len(p.items)
+	case tcell.KeyEnter:
+		e.closePicker()
+		e.StatusMsg = ""
+		p.pick(p.items[p.index])
+	case tcell.KeyRune:
+		switch r := ev.Rune(); {
+		case r == 'k':
+			p.index = (p.index - 1 + len(p.items)) % len(p.items)
+		case r == 'j':
+			p.index = (p.index + 1) % len(p.items)
+		case r == 'q':
+			e.closePicker()
+			e.StatusMsg = ""
+		case r >= '1' && r <= '9':
+			// Straight to one of the first nine
+			for _, item := range p.items {
+				if item.label == string(r) {
+					e.closePicker()
+					e.StatusMsg = ""
+					p.pick(item)
+					break
+				}
+			}
+		}
+	}
+	return true
+}
+
+// DrawPicker draws the picker above the status bar.
+func (e *Editor) DrawPicker() {
+	p := e.picker
+	w, h := e.Screen.Size()
+	width := min(w-2, 72)
+	rows := min(len(p.items), max(1, h-5))
+	if p.index < p.top {
+		p.top = p.index
+	} else if p.index >= p.top+rows {
+		p.top = p.index - rows + 1
+	}
+	x, y := 1, h-3-rows
+
+	title := fmt.Sprintf(" %s (%d/%d) ", p.title, p.index+1, len(p.items))
+	e.drawPickerRow(x, y, width, title, e.Theme.Status)
+	for i := 0; i < rows; i++ {
+		item := p.items[p.top+i]
+		style := e.Theme.Background
+		if p.top+i == p.index {
+			style = e.Theme.Selection
+		}
+		e.drawPickerRow(x, y+1+i, width, fmt.Sprintf(" %3s  %s", item.label, previewLine(item.text)), style)
+	}
+}
+
+func (e *Editor) drawPickerRow(x, y, width int, text string, style tcell.Style) {
+	runes := []rune(text)
+	for i := 0; i < width; i++ {
+		ch := ' '
+		if i < len(runes) {
+			ch = runes[i]
+		}
+		e.Screen.SetContent(x+i, y, ch, nil, style)
+	}
+}
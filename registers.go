@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+const clipRingSize = 32
+
+// Registers a to z hold text apart from the clipboard. A register picked
+// with a prefix (Alt+r a, or "a in vi mode) takes the place of the
+// clipboard for the next copy, cut or paste. Uppercase appends.
+
+func isRegister(r rune) bool {
+	return r >= 'a' && r <= 'z'
+}
+
+func (e *Editor) setRegister(r rune, text string) {
+	if e.registers == nil {
+		e.registers = map[rune]string{}
+	}
+	if unicode.IsUpper(r) {
+		r = unicode.ToLower(r)
+		text = e.registers[r] + text
+	}
+	e.registers[r] = text
+}
+
+// pushClipRing adds a cut or copy to the ring of recent ones, newest last.
+func (e *Editor) pushClipRing(text string) {
+	if text == "" || (len(e.clipRing) > 0 && e.clipRing[len(e.clipRing)-1] == text) {
+		return
+	}
+	e.clipRing = append(e.clipRing, text)
+	if len(e.clipRing) > clipRingSize {
+		e.clipRing = e.clipRing[1:]
+	}
+}
+
+// SelectRegister waits for a register name, then uses that register for
+// the next copy, cut or paste.
+func (e *Editor) SelectRegister() {
+	e.askRegister("Register (a-z, A-Z to append): ", func(r rune) {
+		e.register = r
+		e.StatusMsg = fmt.Sprintf("Register %c: copy, cut or paste", r)
+	})
+}
+
+// CopyToRegister and PasteFromRegister copy or paste straight away once
+// the register is named.
+func (e *Editor) CopyToRegister() {
+	e.askRegister("Copy to register: ", func(r rune) {
+		e.register = r
+		e.runAction("clipboard.copy")
+	})
+}
+
+func (e *Editor) PasteFromRegister() {
+	e.askRegister("Paste from register: ", func(r rune) {
+		e.register = r
+		e.runAction("clipboard.paste")
+	})
+}
+
+// askRegister calls then with the register named by the next key.
+func (e *Editor) askRegister(prompt string, then func(r rune)) {
+	e.registerAction = then
+	e.StatusMsg = prompt
+}
+
+// handleRegisterKey takes the register name a register action is waiting
+// for.
+func (e *Editor) handleRegisterKey(ev *tcell.EventKey) {
+	then := e.registerAction
+	e.registerAction = nil
+	r := ev.Rune()
+	if ev.Key() != tcell.KeyRune || !isRegister(unicode.ToLower(r)) {
+		e.StatusMsg = ""
+		return
+	}
+	then(r)
+}
+
+// YankToRegister copies the selection, or the current line, into register
+// r for the yank command.
+func (e *Editor) YankToRegister(r rune) {
+	buf := e.CurrentBuffer()
+	text := buf.Line(buf.CursorY) + "\n"
+	if start, end, ok := buf.region(); ok {
+		text = buf.textBetween(start, end)
+	}
+	e.setRegister(r, text)
+	e.pushClipRing(text)
+	e.StatusMsg = fmt.Sprintf("Yanked into register %c", unicode.ToLower(r))
+}
+
+func (e *Editor) PutRegister(r rune) {
+	text := e.registers[unicode.ToLower(r)]
+	if text == "" {
+		e.StatusMsg = fmt.Sprintf("Register %c is empty", r)
+		return
+	}
+	if buf := e.CurrentBuffer(); strings.HasSuffix(text, "\n") && len(buf.Cursors) == 0 && !buf.Selection.Active {
+		// Whole lines go in above the cursor's line
+		buf.CursorX = 0
+	}
+	e.InsertPasted(text)
+}
+
+// registerCommand runs yank and put: "yank a" and "put a".
+func (e *Editor) registerCommand(cmd string, args []string) {
+	if len(args) != 1 || len([]rune(args[0])) != 1 || !isRegister(unicode.ToLower([]rune(args[0])[0])) {
+		e.StatusMsg = fmt.Sprintf("Usage: %s <register a-z>", cmd)
+		return
+	}
+	r := []rune(args[0])[0]
+	if cmd == "yank" {
+		e.YankToRegister(r)
+	} else {
+		e.PutRegister(r)
+	}
+}
+
+// ShowClipboardHistory opens a picker with the recent cuts and copies and
+// the registers, to paste one of them.
+func (e *Editor) ShowClipboardHistory() {
+	var items []pickerItem
+	for i := len(e.clipRing) - 1; i >= 0; i-- {
+		items = append(items, pickerItem{label: fmt.Sprint(len(e.clipRing) - i), text: e.clipRing[i]})
+	}
+	for r := 'a'; r <= 'z'; r++ {
+		if text := e.registers[r]; text != "" {
+			items = append(items, pickerItem{label: `"` + string(r), text: text})
+		}
+	}
+	if len(items) == 0 {
+		e.StatusMsg = "Nothing copied yet"
+		return
+	}
+	e.openPicker("Paste", items, func(item pickerItem) {
+		e.InsertPasted(item.text)
+	})
+}
+
+// previewLine shows text on one line.
+func previewLine(text string) string {
+	return strings.NewReplacer("\n", "⏎", "\t", " ").Replace(text)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// cell is what a pane last put on one screen cell.
+type cell struct {
+	Ch    rune
+	Style tcell.Style
+}
+
+// paneFrame remembers the cells a pane drew last frame, so the next frame
+// only sends the ones that changed to the screen.
+type paneFrame struct {
+	X, Y, Width, Height int
+	Cells               []cell
+}
+
+// beginFrame readies pane.frame for drawing, throwing it away if the pane
+// moved or was resized since it was drawn.
+func (pane *Pane) beginFrame() {
+	f := pane.frame
+	if f != nil && f.X == pane.X && f.Y == pane.Y && f.Width == pane.Width && f.Height == pane.Height {
+		return
+	}
+	f = &paneFrame{X: pane.X, Y: pane.Y, Width: pane.Width, Height: pane.Height}
+	f.Cells = make([]cell, max(0, pane.Width*pane.Height))
+	for i := range f.Cells {
+		// Not a rune any pane draws, so the first frame paints everything
+		f.Cells[i].Ch = -1
+	}
+	pane.frame = f
+}
+
+func (e *Editor) setCell(pane *Pane, x, y int, ch rune, style tcell.Style) {
+	if x < 0 || x >= pane.Width || y < 0 || y >= pane.Height {
+		return
+	}
+	c := &pane.frame.Cells[y*pane.Width+x]
+	if c.Ch == ch && c.Style == style {
+		return
+	}
+	c.Ch, c.Style = ch, style
+	e.Screen.SetContent(pane.X+x, pane.Y+y, ch, nil, style)
+}
+
+// InvalidateFrames makes the next Draw repaint every pane.
+func (e *Editor) InvalidateFrames() {
+	for _, pane := range e.Panes {
+		pane.frame = nil
+	}
+}
+
+// matchesOnLine returns the search matches on line. SearchMatches are always
+// collected top to bottom, so they can be binary searched.
+func (e *Editor) matchesOnLine(line int) []SearchMatch {
+	matches := e.SearchMatches
+	i := sort.Search(len(matches), func(i int) bool { return matches[i].Line >= line })
+	j := i
+	for j < len(matches) && matches[j].Line == line {
+		j++
+	}
+	return matches[i:j]
+}
+
+// onLine returns the selected columns [start, end) of line, with end -1 when
+// the selection runs past the end of it.
+func (s Selection) onLine(line int) (start, end int, ok bool) {
+	if !s.Active {
+		return 0, 0, false
+	}
+	startLine, startCol := s.StartLine, s.StartCol
+	endLine, endCol := s.EndLine, s.EndCol
+	if startLine > endLine || (startLine == endLine && startCol > endCol) {
+		startLine, endLine = endLine, startLine
+		startCol, endCol = endCol, startCol
+	}
+	if line < startLine || line > endLine {
+		return 0, 0, false
+	}
+	start, end = 0, -1
+	if line == startLine {
+		start = startCol
+	}
+	if line == endLine {
+		end = endCol
+	}
+	return start, end, true
+}
+
+// onBackground fills in the colors style leaves to the terminal with those
+// of the theme background.
+func onBackground(style, background tcell.Style) tcell.Style {
+	fg, bg, _ := style.Decompose()
+	baseFg, baseBg, _ := background.Decompose()
+	if fg == tcell.ColorDefault {
+		style = style.Foreground(baseFg)
+	}
+	if bg == tcell.ColorDefault {
+		style = style.Background(baseBg)
+	}
+	return style
+}
+
+// lineStyles fills dst with the styles of columns [from, to) of line,
+// applying syntax tokens, then search matches, then the selection, each in a
+// single pass over the spans that touch the range.
+func (e *Editor) lineStyles(dst []tcell.Style, buf *Buffer, line, from, to int) []tcell.Style {
+	background := e.Theme.Background
+	dst = dst[:0]
+	for i := from; i < to; i++ {
+		dst = append(dst, background)
+	}
+	fill := func(start, end int, style tcell.Style) {
+		for i := max(start, from); i < min(end, to); i++ {
+			dst[i-from] = style
+		}
+	}
+	if line < len(buf.TokenCache) {
+		for _, token := range buf.TokenCache[line] {
+			fill(token.Col, token.Col+token.Len, onBackground(token.Style, background))
+		}
+	}
+	for _, match := range e.matchesOnLine(line) {
+		fill(match.Col, match.Col+match.Len, e.Theme.Search)
+	}
+	selection := func(s Selection) {
+		if s.Active && s.Block {
+			top, bottom, left, right := s.blockRect()
+			if line >= top && line <= bottom {
+				start, end := e.blockColumns(buf, line, left, right)
+				fill(start, end, e.Theme.Selection)
+			}
+			return
+		}
+		if start, end, ok := s.onLine(line); ok {
+			if end < 0 {
+				end = to
+			}
+			fill(start, end, e.Theme.Selection)
+		}
+	}
+	selection(buf.Selection)
+	for _, c := range buf.Cursors {
+		selection(c.Selection)
+	}
+	for _, c := range buf.Cursors {
+		// The terminal only shows the main cursor
+		if c.Y == line && c.X >= from && c.X < to {
+			dst[c.X-from] = dst[c.X-from].Reverse(true)
+		}
+	}
+	return dst
+}
+
+// extraCursorAt reports whether one of the extra cursors is at line, col.
+func (b *Buffer) extraCursorAt(line, col int) bool {
+	for _, c := range b.Cursors {
+		if c.Y == line && c.X == col {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func newBenchEditor(b *testing.B, w, h int, lines []string) *Editor {
+	b.Helper()
+	s := tcell.NewSimulationScreen("UTF-8")
+	if err := s.Init(); err != nil {
+		b.Fatal(err)
+	}
+	s.SetSize(w, h)
+	buf := NewBuffer()
+	buf.Filename = "bench.go"
+	buf.Lines = lines
+	buf.SetupHighlighting()
+	buf.HighlightUpTo(len(lines))
+	e := &Editor{Screen: s, Panes: []*Pane{{Buffer: buf}}, Theme: defaultTheme(), Config: defaultConfig()}
+	b.Cleanup(s.Fini)
+	return e
+}
+
+func benchLines(n, width int) []string {
+	line := strings.Repeat("\tfoo := bar(x, \"baz\") // qux", width/28+1)[:width]
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = line
+	}
+	return lines
+}
+
+// Moving the cursor only changes a couple of cells per frame.
+func BenchmarkDrawWide(b *testing.B) {
+	e := newBenchEditor(b, 400, 120, benchLines(1000, 400))
+	buf := e.CurrentBuffer()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.CursorX = i % 300
+		e.Draw()
+	}
+}
+
+func BenchmarkDrawWideScroll(b *testing.B) {
+	e := newBenchEditor(b, 400, 120, benchLines(1000, 400))
+	buf := e.CurrentBuffer()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.OffsetY = i % 800
+		e.Draw()
+	}
+}
+
+func BenchmarkDrawManyMatches(b *testing.B) {
+	e := newBenchEditor(b, 200, 60, benchLines(10000, 200))
+	e.SearchQuery = "o"
+	e.ExecuteSearch()
+	if len(e.SearchMatches) < 100000 {
+		b.Fatalf("only %d matches", len(e.SearchMatches))
+	}
+	buf := e.CurrentBuffer()
+	buf.CursorY, buf.OffsetY = 0, 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.CursorX = i % 150
+		e.Draw()
+	}
+}
+
+func BenchmarkDrawSelection(b *testing.B) {
+	e := newBenchEditor(b, 400, 120, benchLines(1000, 400))
+	buf := e.CurrentBuffer()
+	buf.Selection = Selection{StartLine: 10, StartCol: 5, EndLine: 100, Active: true}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Selection.EndCol = i % 300
+		e.Draw()
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// position is a place in a buffer, in lines and runes.
+type position struct {
+	Line, Col int
+}
+
+func (p position) before(q position) bool {
+	return p.Line < q.Line || (p.Line == q.Line && p.Col < q.Col)
+}
+
+func (b *Buffer) lineLen(line int) int {
+	return len([]rune(b.Line(line)))
+}
+
+func (b *Buffer) firstNonBlank(line int) int {
+	for i, r := range []rune(b.Line(line)) {
+		if !unicode.IsSpace(r) {
+			return i
+		}
+	}
+	return 0
+}
+
+// textBetween returns the text from start up to end.
+func (b *Buffer) textBetween(start, end position) string {
+	if start.Line == end.Line {
+		runes := []rune(b.Line(start.Line))
+		return string(runes[min(start.Col, len(runes)):min(end.Col, len(runes))])
+	}
+	var sb strings.Builder
+	first := []rune(b.Line(start.Line))
+	sb.WriteString(string(first[min(start.Col, len(first)):]))
+	for i := start.Line + 1; i < end.Line; i++ {
+		sb.WriteString("\n")
+		sb.WriteString(b.Line(i))
+	}
+	last := []rune(b.Line(end.Line))
+	sb.WriteString("\n")
+	sb.WriteString(string(last[:min(end.Col, len(last))]))
+	return sb.String()
+}
+
+// deleteBetween removes the text from start up to end and leaves the cursor
+// at start.
+func (b *Buffer) deleteBetween(start, end position) {
+	first := []rune(b.Lines[start.Line])
+	last := []rune(b.Lines[end.Line])
+	joined := string(first[:min(start.Col, len(first))]) + string(last[min(end.Col, len(last)):])
+	b.Lines = append(b.Lines[:start.Line+1], b.Lines[end.Line+1:]...)
+	b.Lines[start.Line] = joined
+	b.CursorY, b.CursorX = start.Line, start.Col
+	b.MarkDirtyLines(start.Line, start.Line)
+}
+
+// deleteLines removes lines start to end, keeping at least one line.
+func (b *Buffer) deleteLines(start, end int) {
+	b.Lines = append(b.Lines[:start], b.Lines[end+1:]...)
+	if len(b.Lines) == 0 {
+		b.Lines = []string{""}
+	}
+	b.MarkDirtyLines(min(start, len(b.Lines)-1), min(start, len(b.Lines)-1))
+	b.CursorY = min(start, len(b.Lines)-1)
+	b.CursorX = b.firstNonBlank(b.CursorY)
+}
+
+// insertText inserts text at the cursor and leaves the cursor after it.
+func (b *Buffer) insertText(text string) {
+	runes := []rune(b.Lines[b.CursorY])
+	col := min(b.CursorX, len(runes))
+	lines := strings.Split(text, "\n")
+	after := string(runes[col:])
+	b.Lines[b.CursorY] = string(runes[:col]) + lines[0]
+	start := b.CursorY
+	if len(lines) > 1 {
+		rest := append([]string(nil), lines[1:]...)
+		rest[len(rest)-1] += after
+		b.Lines = append(b.Lines[:start+1], append(rest, b.Lines[start+1:]...)...)
+		b.CursorY = start + len(rest)
+		b.CursorX = len([]rune(lines[len(lines)-1]))
+	} else {
+		b.Lines[start] += after
+		b.CursorX = col + len([]rune(text))
+	}
+	b.MarkDirtyLines(start, b.CursorY)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/gdamore/tcell/v2"
+)
+
+const defaultColorscheme = "monokai"
+
+// Theme holds the chroma style used for syntax and the colors of the rest
+// of the UI.
+type Theme struct {
+	Name       string
+	Syntax     *chroma.Style
+	Background tcell.Style // the style's Background entry, painted under everything
+	Gutter     tcell.Style
+	Selection  tcell.Style
+	Search     tcell.Style
+	Status     tcell.Style
+	CursorLine tcell.Style // only its background is used; unset leaves the line alone
+	Bracket    tcell.Style // the bracket at the cursor and its partner
+	BadBracket tcell.Style // a bracket at the cursor without one
+}
+
+// themeFile is the on-disk format of a theme, e.g.
+//
+//	base = "monokai"
+//
+//	[syntax]
+//	Keyword = "bold #f92672"
+//	Comment = "italic #75715e"
+//
+//	[ui]
+//	selection = "bg:#49483e"
+//	cursorline = "bg:#3e3d32"
+//
+// Values use chroma's style entry syntax for syntax and UI alike.
+type themeFile struct {
+	Base   string
+	Syntax map[string]string
+	UI     map[string]string
+}
+
+func newTheme(name string, style *chroma.Style) *Theme {
+	background := style.Get(chroma.Background)
+	t := &Theme{
+		Name:       name,
+		Syntax:     style,
+		Background: styleEntryToTcell(background),
+		Gutter:     tcell.StyleDefault.Foreground(tcell.ColorDarkGray),
+		Selection:  tcell.StyleDefault.Background(tcell.ColorBlue).Foreground(tcell.ColorWhite),
+		Search:     tcell.StyleDefault.Background(tcell.ColorYellow).Foreground(tcell.ColorBlack),
+		Status:     tcell.StyleDefault.Background(tcell.ColorGray).Foreground(tcell.ColorBlack),
+		Bracket:    tcell.StyleDefault.Background(tcell.ColorTeal).Foreground(tcell.ColorWhite).Bold(true),
+		BadBracket: tcell.StyleDefault.Background(tcell.ColorRed).Foreground(tcell.ColorWhite).Bold(true),
+	}
+	if style.Has(chroma.LineNumbers) {
+		t.Gutter = styleEntryToTcell(style.Get(chroma.LineNumbers))
+	}
+	// chroma makes up a line highlight from the background, which only
+	// looks right if there is one
+	if background.Background.IsSet() {
+		t.CursorLine = styleEntryToTcell(style.Get(chroma.LineHighlight))
+	}
+	return t
+}
+
+func defaultTheme() *Theme {
+	return newTheme(defaultColorscheme, styles.Get(defaultColorscheme))
+}
+
+func styleEntryToTcell(entry chroma.StyleEntry) tcell.Style {
+	result := tcell.StyleDefault.Foreground(chromaToTcell(entry.Colour)).Background(chromaToTcell(entry.Background))
+	if entry.Bold == chroma.Yes {
+		result = result.Bold(true)
+	}
+	if entry.Italic == chroma.Yes {
+		result = result.Italic(true)
+	}
+	if entry.Underline == chroma.Yes {
+		result = result.Underline(true)
+	}
+	return result
+}
+
+func themeDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "accela", "themes")
+}
+
+// LoadTheme resolves name as a theme file path, a theme in the user's theme
+// directory, or a chroma style, in that order.
+func LoadTheme(name string) (*Theme, error) {
+	if strings.ContainsRune(name, filepath.Separator) || strings.HasSuffix(name, ".toml") {
+		return loadThemeFile(name)
+	}
+	if dir := themeDir(); dir != "" {
+		path := filepath.Join(dir, name+".toml")
+		if _, err := os.Stat(path); err == nil {
+			return loadThemeFile(path)
+		}
+	}
+	if style, ok := styles.Registry[name]; ok {
+		return newTheme(name, style), nil
+	}
+	return nil, fmt.Errorf("unknown colorscheme %q", name)
+}
+
+func loadThemeFile(path string) (*Theme, error) {
+	var tf themeFile
+	if _, err := toml.DecodeFile(path, &tf); err != nil {
+		return nil, err
+	}
+	if tf.Base == "" {
+		tf.Base = defaultColorscheme
+	}
+	base, ok := styles.Registry[tf.Base]
+	if !ok {
+		return nil, fmt.Errorf("%s: unknown base style %q", path, tf.Base)
+	}
+
+	builder := base.Builder()
+	for name, value := range tf.Syntax {
+		ttype, err := chroma.TokenTypeString(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: unknown token type %q", path, name)
+		}
+		if _, err := chroma.ParseStyleEntry(value); err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+		builder.Add(ttype, value)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), ".toml")
+	style, err := builder.Build()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	style.Name = name
+
+	t := newTheme(name, style)
+	fields := map[string]*tcell.Style{
+		"gutter":     &t.Gutter,
+		"selection":  &t.Selection,
+		"search":     &t.Search,
+		"status":     &t.Status,
+		"cursorline": &t.CursorLine,
+		"bracket":    &t.Bracket,
+		"badbracket": &t.BadBracket,
+	}
+	for key, value := range tf.UI {
+		field, ok := fields[key]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown ui color %q", path, key)
+		}
+		entry, err := chroma.ParseStyleEntry(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", path, key, err)
+		}
+		*field = styleEntryToTcell(entry.Inherit(style.Get(chroma.Background)))
+	}
+	return t, nil
+}
+
+// themeNames lists every chroma style and every theme file, for completion.
+func themeNames() []string {
+	names := styles.Names()
+	if entries, err := os.ReadDir(themeDir()); err == nil {
+		for _, entry := range entries {
+			if name, ok := strings.CutSuffix(entry.Name(), ".toml"); ok && !entry.IsDir() {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return slices.Compact(names)
+}
+
+// completeColorscheme completes the colorscheme argument, listing the
+// candidates when there is more than one.
+func (e *Editor) completeColorscheme(args []string) {
+	prefix := ""
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+	var matches []string
+	for _, name := range themeNames() {
+		if strings.HasPrefix(name, prefix) {
+			matches = append(matches, name)
+		}
+	}
+	if len(matches) == 0 {
+		return
+	}
+	common := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	e.Command = "colorscheme " + common
+	if len(matches) > 1 {
+		e.CommandHint = strings.Join(matches, " ")
+	}
+}
+
+// SetTheme switches the theme of the whole editor, re-styling every open
+// buffer.
+func (e *Editor) SetTheme(t *Theme) {
+	e.Theme = t
+	for _, pane := range e.Panes {
+		pane.Buffer.SetStyle(t.Syntax)
+	}
+}
+
+// SetStyle changes the chroma style of b. Lexer checkpoints stay valid, only
+// the tokens have to be styled again.
+func (b *Buffer) SetStyle(style *chroma.Style) {
+	if b.Style == style {
+		return
+	}
+	b.Style = style
+	if b.Lexer == nil || b.ANSI != nil {
+		return
+	}
+	b.TokenCache = make([][]TokenInfo, len(b.Lines))
+	b.DirtyLineStart = 0
+	b.Version++
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+const undoLimit = 200
+
+// undoStep is one change to a buffer: count lines from start replaced what
+// was lines there, with the cursor where it was before.
+type undoStep struct {
+	start   int
+	lines   []string
+	count   int
+	cursorX int
+	cursorY int
+}
+
+// undoHistory holds the changes made to a buffer, newest last. Each key or
+// paste is one step, except that typed characters add to the step before
+// them. A change is found by comparing the lines with base, a copy kept
+// from the step before, so keys that change nothing cost nothing.
+type undoHistory struct {
+	undo    []undoStep
+	redo    []undoStep
+	base    []string
+	version int       // the buffer's Version when base matched its lines
+	pending *position // the cursor before the event being handled
+	typing  bool      // the last step was typing
+	saved   int       // len(undo) when the buffer was saved, -1 if that state is gone
+	// savedNow is set when the buffer is saved during the event being
+	// handled, which may still change it afterwards
+	savedNow bool
+}
+
+// diff returns the step that turns base into lines, if they differ.
+func (h *undoHistory) diff(lines []string) (undoStep, bool) {
+	base := h.base
+	start := 0
+	for start < len(base) && start < len(lines) && base[start] == lines[start] {
+		start++
+	}
+	same := 0
+	for same < len(base)-start && same < len(lines)-start && base[len(base)-1-same] == lines[len(lines)-1-same] {
+		same++
+	}
+	if start == len(base)-same && start == len(lines)-same {
+		return undoStep{}, false
+	}
+	return undoStep{
+		start: start,
+		lines: slices.Clone(base[start : len(base)-same]),
+		count: len(lines) - same - start,
+	}, true
+}
+
+// merge makes one step of prev and next, which came straight after it.
+func (h *undoHistory) merge(prev, next undoStep) undoStep {
+	from := min(prev.start, next.start)
+	to := max(prev.start+prev.count, next.start+len(next.lines))
+	lines := slices.Concat(h.base[from:prev.start], prev.lines, h.base[prev.start+prev.count:to])
+	prev.start, prev.lines, prev.count = from, lines, to-from-len(next.lines)+next.count
+	return prev
+}
+
+// apply makes step's change to b and returns the step that takes it back.
+func (b *Buffer) apply(step undoStep) undoStep {
+	h := &b.history
+	back := undoStep{
+		start:   step.start,
+		lines:   slices.Clone(b.Lines[step.start : step.start+step.count]),
+		count:   len(step.lines),
+		cursorX: b.CursorX,
+		cursorY: b.CursorY,
+	}
+	inSync := h.base != nil && h.version == b.Version
+	b.Lines = slices.Replace(b.Lines, step.start, step.start+step.count, step.lines...)
+	b.CursorX, b.CursorY = step.cursorX, step.cursorY
+	b.Selection.Active = false
+	b.Cursors = nil
+	at := max(step.start-1, 0)
+	b.MarkDirtyLines(at, max(step.start+len(step.lines)-1, at))
+	if inSync {
+		h.base = slices.Replace(h.base, step.start, step.start+step.count, step.lines...)
+		h.version = b.Version
+	}
+	return back
+}
+
+// fits reports whether step can be made to b, as a guard against history
+// left over from other content.
+func (b *Buffer) fits(step undoStep) bool {
+	return step.start+step.count <= len(b.Lines) && step.cursorY < len(b.Lines)-step.count+len(step.lines)
+}
+
+// beginEdit remembers the current buffer before an event is handled, so
+// whatever the event changes can be undone in one step.
+func (e *Editor) beginEdit() *Buffer {
+	buf := e.CurrentBuffer()
+	if buf.ReadOnly || buf.ANSI != nil || buf.Hex != nil || buf.Large != nil {
+		return nil
+	}
+	h := &buf.history
+	if h.base == nil || h.version != buf.Version {
+		// Changed outside of an event, like by loading, which typing
+		// after it does not merge into the step before
+		h.base = slices.Clone(buf.Lines)
+		h.version = buf.Version
+		h.typing = false
+	}
+	h.pending = &position{buf.CursorY, buf.CursorX}
+	return buf
+}
+
+// endEdit adds the step begun by beginEdit if buf changed. typing steps
+// straight after another typing step are merged into it.
+func (e *Editor) endEdit(buf *Buffer, typing bool) {
+	if buf == nil {
+		return
+	}
+	h := &buf.history
+	before, savedNow := h.pending, h.savedNow
+	h.pending, h.savedNow = nil, false
+	step, changed := undoStep{}, false
+	if before != nil && buf.Version != h.version {
+		step, changed = h.diff(buf.Lines)
+	}
+	if !changed {
+		if savedNow {
+			h.saved = len(h.undo)
+		}
+		if !typing {
+			h.typing = false
+		}
+		return
+	}
+	step.cursorX, step.cursorY = before.Col, before.Line
+	h.redo = nil
+	if h.saved > len(h.undo) {
+		h.saved = -1
+	}
+	if typing && h.typing && len(h.undo) > 0 {
+		h.undo[len(h.undo)-1] = h.merge(h.undo[len(h.undo)-1], step)
+	} else {
+		h.undo = append(h.undo, step)
+		if len(h.undo) > undoLimit {
+			h.undo = h.undo[1:]
+			h.saved = max(h.saved-1, -1)
+		}
+	}
+	h.typing = typing
+	h.base = slices.Replace(h.base, step.start, step.start+len(step.lines), buf.Lines[step.start:step.start+step.count]...)
+	h.version = buf.Version
+	if savedNow && buf.Modified {
+		// Changed again after saving, to text no step leads back to
+		h.saved = -1
+	} else if savedNow {
+		h.saved = len(h.undo)
+	}
+}
+
+// markSaved notes that the buffer was written to its file, so undoing back
+// to this point leaves it unmodified.
+func (h *undoHistory) markSaved() {
+	h.typing = false
+	if h.pending != nil {
+		h.savedNow = true
+		return
+	}
+	h.saved = len(h.undo)
+}
+
+func (e *Editor) Undo() {
+	e.undoRedo(false)
+}
+
+func (e *Editor) Redo() {
+	e.undoRedo(true)
+}
+
+// undoRedo takes back the last change, or with redo puts back the last one
+// taken back.
+func (e *Editor) undoRedo(redo bool) {
+	buf := e.CurrentBuffer()
+	if buf.Hex != nil {
+		e.undoHex(buf.Hex, redo)
+		return
+	}
+	h := &buf.history
+	h.pending, h.typing = nil, false
+	if e.readOnly(buf) {
+		return
+	}
+	from, to, name := &h.undo, &h.redo, "Undo"
+	if redo {
+		from, to, name = &h.redo, &h.undo, "Redo"
+	}
+	if len(*from) == 0 {
+		e.StatusMsg = "Already at oldest change"
+		if redo {
+			e.StatusMsg = "Already at newest change"
+		}
+		return
+	}
+	step := (*from)[len(*from)-1]
+	*from = (*from)[:len(*from)-1]
+	if !buf.fits(step) {
+		buf.history = undoHistory{saved: -1}
+		e.StatusMsg = "Undo history no longer matches the buffer, cleared"
+		return
+	}
+	*to = append(*to, buf.apply(step))
+	buf.Modified = h.saved != len(h.undo)
+	e.StatusMsg = fmt.Sprintf("%s (%d more)", name, len(*from))
+	e.ScrollToCursor(e.CurrentPane())
+}
+
+// typed reports whether ev typed text, which goes in the same undo step as
+// the text typed before it.
+func (e *Editor) typed(ev *tcell.EventKey) bool {
+	if e.Config.Keys == "vi" {
+		return e.vi.Mode == viInsert
+	}
+	return ev.Key() == tcell.KeyRune && e.lastAction == "" && !e.CommandMode && !e.SearchMode
+}
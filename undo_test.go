@@ -0,0 +1,162 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+var testKeyNames = map[string]tcell.Key{
+	"<left>": tcell.KeyLeft, "<right>": tcell.KeyRight, "<up>": tcell.KeyUp, "<down>": tcell.KeyDown,
+	"<home>": tcell.KeyHome, "<delete>": tcell.KeyDelete,
+}
+
+// sendInput types each of input in turn. Those that start with "paste:"
+// are pasted as one bracketed paste, and names like "<left>" are sent as
+// their key.
+func sendInput(e *Editor, input []string) {
+	for _, in := range input {
+		if key, ok := testKeyNames[in]; ok {
+			e.HandleEvent(tcell.NewEventKey(key, 0, 0))
+		} else if text, ok := strings.CutPrefix(in, "paste:"); ok {
+			bracketedPaste(e, text)
+		} else {
+			typeKeys(e, in)
+		}
+	}
+}
+
+func TestUndo(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  []string
+		undos  int
+		redos  int
+		want   string
+		cursor position
+	}{
+		{"typing is one step", []string{"abc"}, 1, 0, "x", position{0, 0}},
+		{"enter ends typing", []string{"ab\ncd"}, 1, 0, "ab\nx", position{1, 0}},
+		{"enter is its own step", []string{"ab\ncd"}, 2, 0, "abx", position{0, 2}},
+		{"all of it", []string{"ab\ncd"}, 3, 0, "x", position{0, 0}},
+		{"moving ends typing", []string{"ab", "<left>", "c"}, 1, 0, "abx", position{0, 1}},
+		{"paste is one step", []string{"a", "paste:1\n2\n3"}, 1, 0, "ax", position{0, 1}},
+		{"typing after a paste", []string{"a", "paste:1\n2\n3", "b"}, 1, 0, "a1\n2\n3x", position{2, 1}},
+		{"typing before a paste", []string{"a", "paste:1\n2\n3", "b"}, 3, 0, "x", position{0, 0}},
+		{"redo a paste", []string{"a", "paste:1\n2\n3"}, 1, 1, "a1\n2\n3x", position{2, 1}},
+		{"redo all", []string{"ab\ncd"}, 3, 3, "ab\ncdx", position{1, 2}},
+		{"nothing to undo", nil, 1, 0, "x", position{0, 0}},
+		{"nothing to redo", []string{"a"}, 0, 1, "ax", position{0, 1}},
+	}
+	for _, tt := range tests {
+		e := testEditor(t, "x")
+		buf := e.CurrentBuffer()
+		sendInput(e, tt.input)
+		for range tt.undos {
+			typeKeys(e, "\x1a")
+		}
+		for range tt.redos {
+			typeKeys(e, "\x19")
+		}
+		got, cursor := strings.Join(buf.Lines, "\n"), position{buf.CursorY, buf.CursorX}
+		if got != tt.want || cursor != tt.cursor {
+			t.Errorf("%s: %q cursor %v, want %q %v", tt.name, got, cursor, tt.want, tt.cursor)
+		}
+	}
+}
+
+func TestUndoSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(path, []byte("one\ntwo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := testEditor(t, "")
+	buf := e.CurrentBuffer()
+	if err := buf.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		keys     string
+		first    string
+		modified bool
+	}{
+		{"ab", "abone", true},
+		{"\x1a", "one", false},
+		{"\x19", "abone", true},
+		{"\x13", "abone", false}, // saved here
+		{"c", "abcone", true},
+		{"\x1a", "abone", false},
+		{"\x1a", "one", true},
+		{"\x19", "abone", false},
+		{"\x1a", "one", true},
+		// A new change drops the saved state from the history
+		{"z", "zone", true},
+		{"\x1a", "one", true},
+		// Saving from within an event that then changes the buffer
+		{"\x13", "one", false},
+		{"\x1a", "one", false},
+	}
+	for i, step := range steps {
+		typeKeys(e, step.keys)
+		if buf.Lines[0] != step.first || buf.Modified != step.modified {
+			t.Fatalf("step %d %q: %q modified %v, want %q %v", i, step.keys, buf.Lines[0], buf.Modified, step.first, step.modified)
+		}
+	}
+}
+
+func TestUndoRandom(t *testing.T) {
+	e := testEditor(t, "alpha\nbeta\ngamma\ndelta")
+	buf := e.CurrentBuffer()
+	buf.Filename = "x.go"
+	buf.SetupHighlighting()
+	keys := []string{"x", "y", "\n", "\x7f", "<delete>", "<up>", "<down>", "<left>", "<right>", "<home>", "paste:p\nq"}
+	seed := uint32(7)
+	// Every state an undo should lead back to, oldest first. Few enough
+	// keys that no step is dropped for undoLimit.
+	states := [][]string{slices.Clone(buf.Lines)}
+	for range undoLimit {
+		seed = seed*1664525 + 1013904223
+		before := len(buf.history.undo)
+		sendInput(e, []string{keys[int(seed>>8)%len(keys)]})
+		switch {
+		case len(buf.history.undo) != before:
+			states = append(states, slices.Clone(buf.Lines))
+		case !slices.Equal(states[len(states)-1], buf.Lines):
+			// Typing merged into the step before
+			states[len(states)-1] = slices.Clone(buf.Lines)
+		}
+	}
+	final := slices.Clone(buf.Lines)
+	for i := len(states) - 2; i >= 0; i-- {
+		e.Undo()
+		if !slices.Equal(buf.Lines, states[i]) {
+			t.Fatalf("undo to state %d: %q, want %q", i, buf.Lines, states[i])
+		}
+	}
+	for range states {
+		e.Redo()
+	}
+	if !slices.Equal(buf.Lines, final) {
+		t.Fatalf("redo: %q, want %q", buf.Lines, final)
+	}
+}
+
+func TestUndoLimit(t *testing.T) {
+	e := testEditor(t, "")
+	buf := e.CurrentBuffer()
+	typeKeys(e, strings.Repeat("\n", undoLimit+10))
+	undos := 0
+	for ; undos <= undoLimit; undos++ {
+		typeKeys(e, "\x1a")
+		if e.StatusMsg == "Already at oldest change" {
+			break
+		}
+	}
+	if undos != undoLimit || buf.LineCount() != 11 {
+		t.Errorf("%d undos back to %d lines, want %d back to 11", undos, buf.LineCount(), undoLimit)
+	}
+}
@@ -0,0 +1,1027 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+type viMode int
+
+const (
+	viNormal viMode = iota
+	viInsert
+	viVisual
+	viVisualLine
+)
+
+func (m viMode) String() string {
+	switch m {
+	case viInsert:
+		return "INSERT"
+	case viVisual:
+		return "VISUAL"
+	case viVisualLine:
+		return "VISUAL LINE"
+	}
+	return "NORMAL"
+}
+
+// viState is the modal editing state, used when the keys setting is "vi".
+type viState struct {
+	Mode    viMode
+	pending []rune   // the command typed so far, e.g. "2d"
+	anchor  position // where visual mode started
+
+	keys        []*tcell.EventKey // keys of the current change, for "."
+	recording   bool              // the change went on into insert mode
+	changeCount int
+	lastChange  []*tcell.EventKey // without its count
+	lastCount   int
+	replaying   bool
+
+	lastFind string // last f/t/F/T motion with its character, for ; and ,
+
+	// A count before i, a, o and friends inserts the text that many times
+	insertCmd   string
+	insertCount int
+	insertKeys  []*tcell.EventKey
+}
+
+// viCommand is a parsed normal or visual mode command: [count] op
+// [count] motion, [count] motion, or [count] cmd.
+type viCommand struct {
+	count       int // 0 when none was typed
+	op          rune
+	motionCount int
+	motion      string // e.g. "w", "gg", "fx", "iw", or the operator again for dd
+	cmd         string // e.g. "x", "p", "rx"
+	register    rune   // from a "a prefix, 0 for the clipboard
+}
+
+func (c viCommand) times() int {
+	return max(1, c.count) * max(1, c.motionCount)
+}
+
+// parseViCommand parses keys, reporting whether they make a whole command and
+// whether they can still become one.
+func parseViCommand(keys []rune, visual bool) (cmd viCommand, complete, ok bool) {
+	i := 0
+	readCount := func() int {
+		start := i
+		for i < len(keys) && unicode.IsDigit(keys[i]) && !(i == start && keys[i] == '0') {
+			i++
+		}
+		n, _ := strconv.Atoi(string(keys[start:i]))
+		return n
+	}
+	cmd.count = readCount()
+	if i < len(keys) && keys[i] == '"' {
+		if i+1 == len(keys) {
+			return cmd, false, true
+		}
+		if !isRegister(unicode.ToLower(keys[i+1])) {
+			return cmd, false, false
+		}
+		cmd.register = keys[i+1]
+		i += 2
+		if cmd.count == 0 {
+			cmd.count = readCount()
+		}
+	}
+	if i == len(keys) {
+		return cmd, false, true
+	}
+	c := keys[i]
+	switch {
+	case strings.ContainsRune("dcy", c):
+		i++
+		cmd.op = c
+		if visual {
+			return cmd, i == len(keys), i == len(keys)
+		}
+		cmd.motionCount = readCount()
+		if i < len(keys) && keys[i] == c {
+			cmd.motion = string(c)
+			return cmd, i+1 == len(keys), i+1 == len(keys)
+		}
+		cmd.motion, complete, ok = parseViMotion(keys[i:], true)
+		return cmd, complete, ok
+	case visual && (c == 'i' || c == 'a'):
+		cmd.motion, complete, ok = parseViMotion(keys[i:], true)
+		return cmd, complete, ok
+	case c == 'r', c == 'q', c == '@':
+		if len(keys) == i+1 {
+			return cmd, false, true
+		}
+		if reg := unicode.ToLower(keys[i+1]); c != 'r' && !isRegister(reg) && !(c == '@' && reg == '@') {
+			return cmd, false, false
+		}
+		cmd.cmd = string(keys[i:])
+		return cmd, len(keys) == i+2, len(keys) == i+2
+	case strings.ContainsRune("iaIAoOvVxXDCsSYpPJnNu:/.", c):
+		cmd.cmd = string(c)
+		return cmd, len(keys) == i+1, len(keys) == i+1
+	}
+	cmd.motion, complete, ok = parseViMotion(keys[i:], false)
+	return cmd, complete, ok
+}
+
+func parseViMotion(keys []rune, textObjects bool) (motion string, complete, ok bool) {
+	if len(keys) == 0 {
+		return "", false, true
+	}
+	want := 1
+	switch c := keys[0]; {
+	case strings.ContainsRune("hjklwbe0^$G;,%", c):
+	case c == 'g', strings.ContainsRune("ftFT", c):
+		want = 2
+	case textObjects && (c == 'i' || c == 'a'):
+		want = 2
+		if len(keys) > 1 && !strings.ContainsRune("w\"'`()b{}B[]<>", keys[1]) {
+			return "", false, false
+		}
+	default:
+		return "", false, false
+	}
+	if len(keys) > want || (len(keys) == 2 && keys[0] == 'g' && keys[1] != 'g') {
+		return "", false, false
+	}
+	return string(keys), len(keys) == want, true
+}
+
+// viKeyRune turns the keys vi cares about into the rune that does the same
+// thing in normal mode.
+func viKeyRune(ev *tcell.EventKey) (rune, bool) {
+	switch ev.Key() {
+	case tcell.KeyRune:
+		if ev.Modifiers()&(tcell.ModCtrl|tcell.ModAlt) != 0 {
+			return 0, false
+		}
+		return ev.Rune(), true
+	case tcell.KeyLeft, tcell.KeyBackspace:
+		return 'h', true
+	case tcell.KeyRight:
+		return 'l', true
+	case tcell.KeyUp:
+		return 'k', true
+	case tcell.KeyDown, tcell.KeyEnter:
+		return 'j', true
+	case tcell.KeyHome:
+		return '0', ev.Modifiers() == 0
+	case tcell.KeyEnd:
+		return '$', ev.Modifiers() == 0
+	}
+	return 0, false
+}
+
+// HandleViKey handles ev in vi mode, reporting whether it was used. Keys it
+// leaves alone go through the keymap as usual.
+func (e *Editor) HandleViKey(ev *tcell.EventKey) bool {
+	vi := &e.vi
+	buf := e.CurrentBuffer()
+	if vi.recording && !vi.replaying {
+		vi.keys = append(vi.keys, ev)
+	}
+
+	if vi.Mode == viInsert {
+		if ev.Key() == tcell.KeyEscape {
+			e.viRepeatInsert()
+			if buf.CursorX > 0 {
+				buf.CursorX--
+			}
+			e.viEnterNormal()
+			return true
+		}
+		vi.insertKeys = append(vi.insertKeys, ev)
+		if ev.Key() == tcell.KeyRune && ev.Modifiers()&(tcell.ModCtrl|tcell.ModAlt) == 0 {
+			e.InsertRune(ev.Rune())
+			return true
+		}
+		return false
+	}
+
+	if ev.Key() == tcell.KeyEscape {
+		if len(vi.pending) == 0 && vi.Mode != viNormal {
+			e.viEnterNormal()
+		}
+		vi.pending = nil
+		e.StatusMsg = ""
+		return true
+	}
+	r, ok := viKeyRune(ev)
+	if !ok {
+		vi.pending = nil
+		return false
+	}
+	if r == 'q' && len(vi.pending) == 0 && e.macro.recording != 0 {
+		e.StopMacroRecording()
+		return true
+	}
+	if len(vi.pending) == 0 && !vi.replaying {
+		vi.keys = vi.keys[:0]
+	}
+	if !vi.replaying {
+		vi.keys = append(vi.keys, ev)
+	}
+	vi.pending = append(vi.pending, r)
+
+	visual := vi.Mode == viVisual || vi.Mode == viVisualLine
+	cmd, complete, ok := parseViCommand(vi.pending, visual)
+	if !ok {
+		vi.pending = nil
+		return true
+	}
+	if !complete {
+		return true
+	}
+	vi.pending = nil
+
+	changed := false
+	e.register = cmd.register
+	if visual {
+		changed = e.viVisualCommand(cmd)
+	} else {
+		changed = e.viNormalCommand(cmd)
+	}
+	e.register = 0
+	if changed && !vi.replaying {
+		vi.changeCount = cmd.count
+		if vi.Mode == viInsert {
+			vi.recording = true
+		} else {
+			vi.saveChange()
+		}
+	}
+	if vi.Mode != viInsert {
+		e.viClampCursor()
+	}
+	e.viUpdateSelection()
+	e.ScrollToCursor(e.CurrentPane())
+	return true
+}
+
+// saveChange keeps the keys of the change just made for ".", leaving out
+// the count so "." can be given another.
+func (vi *viState) saveChange() {
+	keys := vi.keys
+	for len(keys) > 0 && keys[0].Key() == tcell.KeyRune && unicode.IsDigit(keys[0].Rune()) && (keys[0].Rune() != '0' || len(keys) < len(vi.keys)) {
+		keys = keys[1:]
+	}
+	vi.lastChange = append([]*tcell.EventKey(nil), keys...)
+	vi.lastCount = vi.changeCount
+}
+
+func (e *Editor) viEnterNormal() {
+	vi := &e.vi
+	if vi.recording && !vi.replaying {
+		vi.saveChange()
+	}
+	vi.recording = false
+	vi.Mode = viNormal
+	e.CurrentBuffer().Selection.Active = false
+	e.viClampCursor()
+}
+
+func (e *Editor) viEnterInsert(cmd string, count int) {
+	e.vi.Mode = viInsert
+	e.vi.insertCmd, e.vi.insertCount, e.vi.insertKeys = cmd, count, nil
+	e.CurrentBuffer().Selection.Active = false
+}
+
+// viRepeatInsert types what was typed in insert mode again for the rest of
+// the count.
+func (e *Editor) viRepeatInsert() {
+	vi := &e.vi
+	keys, count := vi.insertKeys, vi.insertCount
+	vi.insertKeys, vi.insertCount = nil, 0
+	replaying := vi.replaying
+	vi.replaying = true
+	defer func() { vi.replaying = replaying }()
+	for i := 1; i < count; i++ {
+		if vi.insertCmd == "o" || vi.insertCmd == "O" {
+			e.InsertNewline()
+		}
+		for _, ev := range keys {
+			e.HandleKey(ev)
+		}
+	}
+}
+
+// viRepeat replays the last change, with count in place of the one it was
+// typed with if given.
+func (e *Editor) viRepeat(count int) {
+	vi := &e.vi
+	if len(vi.lastChange) == 0 || vi.replaying {
+		return
+	}
+	if count == 0 {
+		count = vi.lastCount
+	}
+	vi.replaying = true
+	defer func() { vi.replaying = false }()
+	if count > 0 {
+		for _, r := range strconv.Itoa(count) {
+			e.HandleKey(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+		}
+	}
+	for _, ev := range vi.lastChange {
+		e.HandleKey(ev)
+	}
+	if vi.Mode == viInsert {
+		e.viEnterNormal()
+	}
+	vi.lastCount = count
+}
+
+// viClampCursor keeps the cursor on a character, as normal mode has no
+// position past the end of a line.
+func (e *Editor) viClampCursor() {
+	buf := e.CurrentBuffer()
+	buf.CursorY = min(max(buf.CursorY, 0), buf.LineCount()-1)
+	buf.CursorX = max(0, min(buf.CursorX, buf.lineLen(buf.CursorY)-1))
+}
+
+// viUpdateSelection shows the visual mode range as the selection.
+func (e *Editor) viUpdateSelection() {
+	buf := e.CurrentBuffer()
+	if e.vi.Mode != viVisual && e.vi.Mode != viVisualLine {
+		return
+	}
+	start, end, _ := e.viVisualRange()
+	buf.Selection = Selection{StartLine: start.Line, StartCol: start.Col, EndLine: end.Line, EndCol: end.Col, Active: true}
+	if e.vi.Mode == viVisualLine {
+		// Past the end, so the whole last line shows as selected
+		buf.Selection.EndCol = 1 << 30
+	}
+}
+
+// viAnchor is where visual mode started, moved back inside the buffer if
+// something other than vi, like undo, has since made it shorter.
+func (e *Editor) viAnchor() position {
+	buf := e.CurrentBuffer()
+	a := &e.vi.anchor
+	a.Line = min(max(a.Line, 0), buf.LineCount()-1)
+	a.Col = min(max(a.Col, 0), buf.lineLen(a.Line))
+	return *a
+}
+
+func (e *Editor) viVisualRange() (start, end position, linewise bool) {
+	buf := e.CurrentBuffer()
+	start, end = e.viAnchor(), position{buf.CursorY, buf.CursorX}
+	if end.before(start) {
+		start, end = end, start
+	}
+	if e.vi.Mode == viVisualLine {
+		return position{start.Line, 0}, position{end.Line, buf.lineLen(end.Line)}, true
+	}
+	end.Col = min(end.Col+1, buf.lineLen(end.Line))
+	return start, end, false
+}
+
+// viMotion works out where motion takes the cursor. inclusive motions take
+// the character they land on with them under an operator; linewise ones take
+// whole lines.
+func (e *Editor) viMotion(motion string, count int, forOperator bool) (pos position, inclusive, linewise, ok bool) {
+	buf := e.CurrentBuffer()
+	cur := position{buf.CursorY, buf.CursorX}
+	n := max(1, count)
+	last := buf.LineCount() - 1
+	switch motion[0] {
+	case 'h':
+		return position{cur.Line, max(0, cur.Col-n)}, false, false, cur.Col > 0
+	case 'l':
+		limit := buf.lineLen(cur.Line)
+		if !forOperator {
+			limit--
+		}
+		return position{cur.Line, max(0, min(limit, cur.Col+n))}, false, false, cur.Col < limit
+	case 'j':
+		line := min(last, cur.Line+n)
+		return position{line, cur.Col}, false, true, line != cur.Line
+	case 'k':
+		line := max(0, cur.Line-n)
+		return position{line, cur.Col}, false, true, line != cur.Line
+	case 'w', 'b', 'e':
+		saveX, saveY := buf.CursorX, buf.CursorY
+		for i := 0; i < n; i++ {
+			switch motion[0] {
+			case 'w':
+				buf.MoveWordRight()
+			case 'b':
+				buf.MoveWordLeft()
+			case 'e':
+				buf.moveWordEnd()
+			}
+		}
+		pos = position{buf.CursorY, buf.CursorX}
+		buf.CursorX, buf.CursorY = saveX, saveY
+		return pos, motion[0] == 'e', false, pos != cur
+	case '%':
+		// The first bracket from the cursor on, as in vim
+		for col := cur.Col; col < buf.lineLen(cur.Line); col++ {
+			if _, ok := buf.bracketAt(position{cur.Line, col}); ok {
+				match, ok := buf.matchBracket(position{cur.Line, col})
+				return match, true, false, ok
+			}
+		}
+		return cur, false, false, false
+	case '0':
+		return position{cur.Line, 0}, false, false, true
+	case '^':
+		return position{cur.Line, buf.firstNonBlank(cur.Line)}, false, false, true
+	case '$':
+		line := min(last, cur.Line+n-1)
+		return position{line, max(0, buf.lineLen(line)-1)}, true, false, true
+	case 'g', 'G':
+		line := 0
+		if motion == "G" {
+			line = last
+		}
+		if count > 0 {
+			line = min(last, count-1)
+		}
+		return position{line, buf.firstNonBlank(line)}, false, true, true
+	case ';', ',':
+		find := e.vi.lastFind
+		if find == "" {
+			return cur, false, false, false
+		}
+		if motion == "," {
+			swap := map[byte]byte{'f': 'F', 'F': 'f', 't': 'T', 'T': 't'}
+			find = string(swap[find[0]]) + find[1:]
+		}
+		return e.viFind(find, n, true)
+	case 'f', 't', 'F', 'T':
+		e.vi.lastFind = motion
+		return e.viFind(motion, n, false)
+	}
+	return cur, false, false, false
+}
+
+// viFind finds the count'th character of find on the cursor line; find is
+// the motion and its character, e.g. "fx".
+func (e *Editor) viFind(find string, count int, repeat bool) (pos position, inclusive, linewise, ok bool) {
+	buf := e.CurrentBuffer()
+	runes := []rune(buf.Line(buf.CursorY))
+	target := []rune(find)[1]
+	col := buf.CursorX
+	forward := find[0] == 'f' || find[0] == 't'
+	till := find[0] == 't' || find[0] == 'T'
+	step := 1
+	if !forward {
+		step = -1
+	}
+	if repeat && till {
+		// Repeating t would otherwise stop right away
+		col += step
+	}
+	for count > 0 {
+		col += step
+		if col < 0 || col >= len(runes) {
+			return position{}, false, false, false
+		}
+		if runes[col] == target {
+			count--
+		}
+	}
+	if till {
+		col -= step
+	}
+	return position{buf.CursorY, col}, forward, false, true
+}
+
+// moveWordEnd moves to the last character of the next word.
+func (b *Buffer) moveWordEnd() {
+	runes := []rune(b.Line(b.CursorY))
+	b.CursorX++
+	for {
+		for b.CursorX < len(runes) && !isWordChar(runes[b.CursorX]) {
+			b.CursorX++
+		}
+		if b.CursorX < len(runes) || b.CursorY >= b.LineCount()-1 {
+			break
+		}
+		b.CursorY++
+		b.CursorX = 0
+		runes = []rune(b.Line(b.CursorY))
+	}
+	for b.CursorX+1 < len(runes) && isWordChar(runes[b.CursorX+1]) {
+		b.CursorX++
+	}
+	b.CursorX = min(b.CursorX, max(0, len(runes)-1))
+}
+
+// viTextObject returns the range of a text object such as "iw" or "a(" around
+// the cursor, end exclusive. The inside of a block that spans lines is whole
+// lines.
+func (e *Editor) viTextObject(object string) (start, end position, linewise, ok bool) {
+	buf := e.CurrentBuffer()
+	line := buf.CursorY
+	runes := []rune(buf.Line(line))
+	col := buf.CursorX
+	inner := object[0] == 'i'
+	kind := []rune(object)[1]
+
+	switch kind {
+	case 'w':
+		if len(runes) == 0 {
+			return position{line, 0}, position{line, 0}, false, true
+		}
+		col = min(col, len(runes)-1)
+		class := func(r rune) int {
+			switch {
+			case isWordChar(r):
+				return 0
+			case unicode.IsSpace(r):
+				return 1
+			}
+			return 2
+		}
+		c := class(runes[col])
+		s, t := col, col+1
+		for s > 0 && class(runes[s-1]) == c {
+			s--
+		}
+		for t < len(runes) && class(runes[t]) == c {
+			t++
+		}
+		if !inner {
+			if t < len(runes) && unicode.IsSpace(runes[t]) {
+				for t < len(runes) && unicode.IsSpace(runes[t]) {
+					t++
+				}
+			} else {
+				for s > 0 && unicode.IsSpace(runes[s-1]) {
+					s--
+				}
+			}
+		}
+		return position{line, s}, position{line, t}, false, true
+
+	case '"', '\'', '`':
+		if len(runes) == 0 {
+			return start, end, false, false
+		}
+		col = min(col, len(runes)-1)
+		s := -1
+		for i := col; i >= 0; i-- {
+			if runes[i] == kind {
+				s = i
+				break
+			}
+		}
+		from := col + 1
+		if s < 0 {
+			// Not inside quotes: use the next pair on the line
+			for i := col; i < len(runes); i++ {
+				if runes[i] == kind {
+					s = i
+					break
+				}
+			}
+			from = s + 1
+		}
+		if s < 0 {
+			return start, end, false, false
+		}
+		t := -1
+		for i := from; i < len(runes); i++ {
+			if runes[i] == kind {
+				t = i
+				break
+			}
+		}
+		if t < 0 {
+			return start, end, false, false
+		}
+		if inner {
+			return position{line, s + 1}, position{line, t}, false, true
+		}
+		return position{line, s}, position{line, t + 1}, false, true
+	}
+
+	pairs := map[rune][2]rune{
+		'(': {'(', ')'}, ')': {'(', ')'}, 'b': {'(', ')'},
+		'{': {'{', '}'}, '}': {'{', '}'}, 'B': {'{', '}'},
+		'[': {'[', ']'}, ']': {'[', ']'},
+		'<': {'<', '>'}, '>': {'<', '>'},
+	}
+	pair, found := pairs[kind]
+	if !found {
+		return start, end, false, false
+	}
+	open, ok := buf.findUnmatched(position{line, col}, pair[0], pair[1], -1)
+	if !ok {
+		return start, end, false, false
+	}
+	closing, ok := buf.findUnmatched(open, pair[1], pair[0], 1)
+	if !ok {
+		return start, end, false, false
+	}
+	if !inner {
+		return open, position{closing.Line, closing.Col + 1}, false, true
+	}
+	start, end = position{open.Line, open.Col + 1}, closing
+	if start.Col >= buf.lineLen(start.Line) && strings.TrimSpace(string([]rune(buf.Line(end.Line))[:end.Col])) == "" {
+		// The lines between { and }
+		if start.Line+1 > end.Line-1 {
+			return start, start, false, true
+		}
+		return position{start.Line + 1, 0}, position{end.Line - 1, 0}, true, true
+	}
+	return start, end, false, true
+}
+
+// findUnmatched looks from pos in direction dir for a want that is not
+// balanced by a counterpart on the way. The character at pos counts only
+// if it is want.
+func (b *Buffer) findUnmatched(pos position, want, counterpart rune, dir int) (position, bool) {
+	depth := 0
+	line, col := pos.Line, pos.Col
+	runes := []rune(b.Line(line))
+	first := true
+	for {
+		if col >= 0 && col < len(runes) {
+			switch r := runes[col]; {
+			case r == want && depth == 0:
+				return position{line, col}, true
+			case r == want:
+				depth--
+			case r == counterpart && !first:
+				depth++
+			}
+		}
+		first = false
+		col += dir
+		for col < 0 || col >= len(runes) {
+			line += dir
+			if line < 0 || line >= b.LineCount() {
+				return pos, false
+			}
+			runes = []rune(b.Line(line))
+			if dir > 0 {
+				col = 0
+			} else {
+				col = len(runes) - 1
+			}
+			if len(runes) > 0 {
+				break
+			}
+		}
+	}
+}
+
+// viOperate applies op to the text from start up to end, or to the lines
+// start to end when linewise.
+func (e *Editor) viOperate(op rune, start, end position, linewise bool) {
+	buf := e.CurrentBuffer()
+	if op != 'y' && e.readOnly(buf) {
+		return
+	}
+	if linewise {
+		var lines []string
+		for i := start.Line; i <= end.Line; i++ {
+			lines = append(lines, buf.Line(i))
+		}
+		e.writeClipboard(strings.Join(lines, "\n") + "\n")
+		switch op {
+		case 'y':
+			buf.CursorY = start.Line
+			if n := len(lines); n > 2 {
+				e.StatusMsg = fmt.Sprintf("%d lines yanked", n)
+			}
+		case 'd':
+			buf.deleteLines(start.Line, end.Line)
+		case 'c':
+			buf.Lines = append(buf.Lines[:start.Line+1], buf.Lines[end.Line+1:]...)
+			buf.Lines[start.Line] = ""
+			buf.MarkDirtyLines(start.Line, start.Line)
+			buf.CursorY, buf.CursorX = start.Line, 0
+			e.viEnterInsert("c", 1)
+		}
+		return
+	}
+
+	e.writeClipboard(buf.textBetween(start, end))
+	switch op {
+	case 'y':
+		buf.CursorY, buf.CursorX = start.Line, start.Col
+	case 'd':
+		buf.deleteBetween(start, end)
+	case 'c':
+		buf.deleteBetween(start, end)
+		e.viEnterInsert("c", 1)
+	}
+}
+
+func (e *Editor) viNormalCommand(cmd viCommand) (changed bool) {
+	buf := e.CurrentBuffer()
+	cur := position{buf.CursorY, buf.CursorX}
+	n := max(1, cmd.count)
+
+	if cmd.op != 0 {
+		if cmd.motion == string(cmd.op) {
+			// dd, cc, yy
+			last := min(buf.LineCount()-1, cur.Line+cmd.times()-1)
+			e.viOperate(cmd.op, position{cur.Line, 0}, position{last, 0}, true)
+			return cmd.op != 'y'
+		}
+		motion := cmd.motion
+		if cmd.op == 'c' && motion == "w" {
+			// cw changes to the end of the word, like ce
+			if runes := []rune(buf.Line(cur.Line)); cur.Col < len(runes) && !unicode.IsSpace(runes[cur.Col]) {
+				motion = "e"
+				if isWordChar(runes[cur.Col]) && (cur.Col+1 >= len(runes) || !isWordChar(runes[cur.Col+1])) {
+					// Already on the last character of the word
+					e.viOperate('c', cur, position{cur.Line, cur.Col + 1}, false)
+					return true
+				}
+			}
+		}
+		var start, end position
+		var linewise bool
+		if motion[0] == 'i' || motion[0] == 'a' {
+			var ok bool
+			if start, end, linewise, ok = e.viTextObject(motion); !ok {
+				return false
+			}
+		} else {
+			count := cmd.count * max(1, cmd.motionCount)
+			if cmd.count == 0 {
+				count = cmd.motionCount
+			}
+			pos, inclusive, lw, ok := e.viMotion(motion, count, true)
+			if !ok {
+				e.motionFailed = true
+				return false
+			}
+			linewise = lw
+			start, end = cur, pos
+			if end.before(start) {
+				start, end = end, start
+			}
+			if inclusive {
+				end.Col++
+			} else if !linewise && end.Line > start.Line && end.Col == 0 {
+				// An exclusive motion that ends at the start of a line
+				// stops at the end of the line before
+				end = position{end.Line - 1, buf.lineLen(end.Line - 1)}
+			}
+		}
+		e.viOperate(cmd.op, start, end, linewise)
+		return cmd.op != 'y'
+	}
+
+	if cmd.motion != "" {
+		pos, _, _, ok := e.viMotion(cmd.motion, cmd.count, false)
+		if ok {
+			buf.CursorY, buf.CursorX = pos.Line, pos.Col
+		}
+		e.viMotionFailed(cur, pos, cmd.motion, ok)
+		return false
+	}
+
+	lineLen := buf.lineLen(cur.Line)
+	switch c := cmd.cmd; c[0] {
+	case 'i', 'a', 'I', 'A', 'o', 'O', 's', 'S', 'C', 'x', 'X', 'D', 'p', 'P', 'J', 'r':
+		if e.readOnly(buf) {
+			return false
+		}
+	}
+	switch c := cmd.cmd; c[0] {
+	case 'u':
+		for i := 0; i < n; i++ {
+			e.Undo()
+		}
+	case 'i':
+		e.viEnterInsert(c, n)
+	case 'a':
+		buf.CursorX = min(cur.Col+1, lineLen)
+		e.viEnterInsert(c, n)
+	case 'I':
+		buf.CursorX = buf.firstNonBlank(cur.Line)
+		e.viEnterInsert(c, n)
+	case 'A':
+		buf.CursorX = lineLen
+		e.viEnterInsert(c, n)
+	case 'o':
+		buf.CursorX = lineLen
+		e.InsertNewline()
+		e.viEnterInsert(c, n)
+	case 'O':
+		buf.CursorX = 0
+		e.InsertNewline()
+		buf.CursorY--
+		e.viEnterInsert(c, n)
+	case 'v':
+		e.vi.Mode = viVisual
+		e.vi.anchor = cur
+		return false
+	case 'V':
+		e.vi.Mode = viVisualLine
+		e.vi.anchor = cur
+		return false
+	case 'x':
+		if lineLen == 0 {
+			return false
+		}
+		e.viOperate('d', cur, position{cur.Line, min(lineLen, cur.Col+n)}, false)
+	case 'X':
+		if cur.Col == 0 {
+			return false
+		}
+		e.viOperate('d', position{cur.Line, max(0, cur.Col-n)}, cur, false)
+	case 's':
+		e.viOperate('c', cur, position{cur.Line, min(lineLen, cur.Col+n)}, false)
+	case 'S':
+		e.viOperate('c', cur, position{min(buf.LineCount()-1, cur.Line+n-1), 0}, true)
+	case 'D', 'C':
+		end, _, _, _ := e.viMotion("$", n, true)
+		op := 'd'
+		if c[0] == 'C' {
+			op = 'c'
+		}
+		e.viOperate(op, cur, position{end.Line, buf.lineLen(end.Line)}, false)
+	case 'Y':
+		e.viOperate('y', cur, position{min(buf.LineCount()-1, cur.Line+n-1), 0}, true)
+		return false
+	case 'p', 'P':
+		e.viPut(c[0] == 'p', n)
+	case 'J':
+		e.viJoin(max(2, n))
+	case 'r':
+		with := []rune(c)[1]
+		runes := []rune(buf.Line(cur.Line))
+		if cur.Col+n > len(runes) {
+			e.motionFailed = true
+			return false
+		}
+		for i := cur.Col; i < cur.Col+n; i++ {
+			runes[i] = with
+		}
+		buf.Lines[cur.Line] = string(runes)
+		buf.CursorX = cur.Col + n - 1
+		buf.MarkDirty()
+	case 'n':
+		for i := 0; i < n; i++ {
+			e.SearchNext()
+		}
+		return false
+	case 'N':
+		for i := 0; i < n; i++ {
+			e.SearchPrev()
+		}
+		return false
+	case ':':
+		e.OpenCommandBar()
+		return false
+	case '/':
+		e.OpenSearch()
+		return false
+	case '.':
+		e.viRepeat(cmd.count)
+		return false
+	case 'q':
+		e.StartMacroRecording([]rune(c)[1])
+		return false
+	case '@':
+		e.PlayMacro([]rune(c)[1], n)
+		return false
+	}
+	return true
+}
+
+// viMotionFailed notes a motion that could not move, like j on the last
+// line, which stops a macro. 0, $ and the like never fail.
+func (e *Editor) viMotionFailed(cur, pos position, motion string, ok bool) {
+	if !ok || (pos == cur && strings.ContainsRune("hjklwbe", rune(motion[0]))) {
+		e.motionFailed = true
+	}
+}
+
+func (e *Editor) viVisualCommand(cmd viCommand) (changed bool) {
+	buf := e.CurrentBuffer()
+	switch {
+	case cmd.op != 0:
+		start, end, linewise := e.viVisualRange()
+		e.viEnterNormal()
+		e.viOperate(cmd.op, start, end, linewise)
+		return cmd.op != 'y'
+	case cmd.motion != "" && (cmd.motion[0] == 'i' || cmd.motion[0] == 'a'):
+		start, end, linewise, ok := e.viTextObject(cmd.motion)
+		if !ok {
+			return false
+		}
+		if linewise {
+			e.vi.Mode = viVisualLine
+		}
+		if e.vi.anchor.before(start) || e.vi.anchor == (position{buf.CursorY, buf.CursorX}) {
+			e.vi.anchor = start
+		}
+		buf.CursorY, buf.CursorX = end.Line, max(0, end.Col-1)
+	case cmd.motion != "":
+		cur := position{buf.CursorY, buf.CursorX}
+		pos, _, _, ok := e.viMotion(cmd.motion, cmd.count, false)
+		if ok {
+			buf.CursorY, buf.CursorX = pos.Line, pos.Col
+		}
+		e.viMotionFailed(cur, pos, cmd.motion, ok)
+	}
+	switch cmd.cmd {
+	case "x", "X", "D":
+		return e.viVisualCommand(viCommand{op: 'd'})
+	case "s", "S", "C":
+		return e.viVisualCommand(viCommand{op: 'c'})
+	case "Y":
+		return e.viVisualCommand(viCommand{op: 'y'})
+	case "o", "O":
+		cur, anchor := position{buf.CursorY, buf.CursorX}, e.viAnchor()
+		buf.CursorY, buf.CursorX = anchor.Line, anchor.Col
+		e.vi.anchor = cur
+	case "v", "V":
+		mode := viVisual
+		if cmd.cmd == "V" {
+			mode = viVisualLine
+		}
+		if e.vi.Mode == mode {
+			e.viEnterNormal()
+		} else {
+			e.vi.Mode = mode
+		}
+	case ":":
+		e.viEnterNormal()
+		e.OpenCommandBar()
+	}
+	return false
+}
+
+// viPut puts the clipboard after or before the cursor count times. Text
+// ending in a newline was yanked by lines and goes in as whole lines.
+func (e *Editor) viPut(after bool, count int) {
+	buf := e.CurrentBuffer()
+	text, err := e.readClipboard()
+	if err != nil {
+		e.StatusMsg = fmt.Sprintf("Error: %v", err)
+	}
+	if text == "" {
+		return
+	}
+	if strings.HasSuffix(text, "\n") {
+		lines := strings.Split(strings.Repeat(text, count), "\n")
+		lines = lines[:len(lines)-1]
+		at := buf.CursorY
+		if after {
+			at++
+		}
+		buf.Lines = append(buf.Lines[:at], append(lines, buf.Lines[at:]...)...)
+		buf.MarkDirtyLines(max(0, at-1), at+len(lines))
+		buf.CursorY = at
+		buf.CursorX = buf.firstNonBlank(at)
+		return
+	}
+	if after && buf.lineLen(buf.CursorY) > 0 {
+		buf.CursorX++
+	}
+	buf.insertText(strings.Repeat(text, count))
+	buf.CursorX = max(0, buf.CursorX-1)
+}
+
+// viJoin joins count lines starting at the cursor line, separated by a space.
+func (e *Editor) viJoin(count int) {
+	buf := e.CurrentBuffer()
+	line := buf.CursorY
+	for i := 1; i < count && line+1 < buf.LineCount(); i++ {
+		current := strings.TrimRightFunc(buf.Lines[line], unicode.IsSpace)
+		next := strings.TrimLeftFunc(buf.Lines[line+1], unicode.IsSpace)
+		buf.CursorX = len([]rune(current))
+		if current != "" && next != "" && !strings.HasPrefix(next, ")") {
+			current += " "
+		}
+		buf.Lines[line] = current + next
+		buf.Lines = append(buf.Lines[:line+1], buf.Lines[line+2:]...)
+		buf.MarkDirtyLines(line, line)
+	}
+}
+
+// viModeName is what the status bar shows for vi mode, with any command
+// still being typed.
+func (e *Editor) viModeName() string {
+	if len(e.vi.pending) > 0 {
+		return e.vi.Mode.String() + " " + string(e.vi.pending)
+	}
+	return e.vi.Mode.String()
+}
+
+func (e *Editor) cursorStyle() tcell.CursorStyle {
+	if e.Config.Keys != "vi" {
+		return tcell.CursorStyleDefault
+	}
+	if e.vi.Mode == viInsert {
+		return tcell.CursorStyleSteadyBar
+	}
+	return tcell.CursorStyleSteadyBlock
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// testEditor is an editor drawing to an 80x24 simulation screen.
+func testEditor(t *testing.T, text string) *Editor {
+	t.Helper()
+	s := tcell.NewSimulationScreen("UTF-8")
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	s.SetSize(80, 24)
+	buf := NewBuffer()
+	buf.Lines = strings.Split(text, "\n")
+	return &Editor{Screen: s, Panes: []*Pane{{Buffer: buf, Width: 80, Height: 22}}, Theme: defaultTheme(), Config: defaultConfig()}
+}
+
+// typeKeys sends keys to e one at a time. ESC, newline and tab are sent as
+// their keys, and the other control characters as Ctrl with their letter.
+func typeKeys(e *Editor, keys string) {
+	for _, r := range keys {
+		switch {
+		case r == '\x1b':
+			e.HandleEvent(tcell.NewEventKey(tcell.KeyEscape, 0, 0))
+		case r == '\n':
+			e.HandleEvent(tcell.NewEventKey(tcell.KeyEnter, 0, 0))
+		case r == '\t':
+			e.HandleEvent(tcell.NewEventKey(tcell.KeyTab, 0, 0))
+		case r < ' ':
+			e.HandleEvent(tcell.NewEventKey(tcell.KeyRune, r+'a'-1, tcell.ModCtrl))
+		default:
+			e.HandleEvent(tcell.NewEventKey(tcell.KeyRune, r, 0))
+		}
+	}
+}
+
+func TestViVisualAfterUndo(t *testing.T) {
+	tests := []struct {
+		text, keys, want string
+	}{
+		// Undo takes away the line visual mode started on
+		{"a\nb\nc\nd", "Gox\x1bV\x1ak", "a\nb\nc\nd"},
+		{"a\nb\nc\nd", "Gox\x1bV\x1akd", "a\nb"},
+		{"a\nb\nc\nd", "Gox\x1bV\x1ao", "a\nb\nc\nd"},
+		{"ab\ncd", "$afoo\x1bv\x1ay", "ab\ncd"},
+	}
+	for _, tt := range tests {
+		e := testEditor(t, tt.text)
+		e.Config.Keys = "vi"
+		e.Config.Clipboard = "internal"
+		typeKeys(e, tt.keys)
+		e.Draw()
+		if got := strings.Join(e.CurrentBuffer().Lines, "\n"); got != tt.want {
+			t.Errorf("%q after %q: %q, want %q", tt.text, tt.keys, got, tt.want)
+		}
+	}
+}